@@ -0,0 +1,175 @@
+package discover
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gabrielperezs/discover/resource"
+)
+
+// State of the Discover served by the admin Handler
+type State struct {
+	Label     string          `json:"label"`
+	Balancing string          `json:"balancing"`
+	LocalZone string          `json:"local_zone,omitempty"`
+	Panic     bool            `json:"panic"`
+	Ready     bool            `json:"ready"`
+	Health    string          `json:"health,omitempty"`
+	Plugins   []PluginState   `json:"plugins"`
+	Resources []ResourceState `json:"resources"`
+}
+
+// PluginState is the source URI of the plugin with the time of its last
+// update and its Status
+type PluginState struct {
+	Source              string    `json:"source"`
+	LastUpdate          time.Time `json:"last_update"`
+	LastSuccess         time.Time `json:"last_success"`
+	Error               string    `json:"error,omitempty"`
+	ErrorTime           time.Time `json:"error_time"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// ResourceState is a snapshot of a resource
+type ResourceState struct {
+	Host      string `json:"host"`
+	Source    string `json:"source"`
+	Protocol  string `json:"protocol"`
+	Zone      string `json:"zone,omitempty"`
+	Priority  int    `json:"priority"`
+	Healthy   bool   `json:"healthy"`
+	Available bool   `json:"available"`
+	Ejected   bool   `json:"ejected"`
+	Circuit   string `json:"circuit"`
+	// Override is drain, disable or pin when it was set by hand
+	Override      string    `json:"override,omitempty"`
+	OverrideUntil time.Time `json:"override_until"`
+	LastUpdate    time.Time `json:"last_update"`
+	LastCheck     time.Time `json:"last_check"`
+	CheckError    string    `json:"check_error,omitempty"`
+	InFlight      int64     `json:"in_flight"`
+	Weight        float64   `json:"weight"`
+}
+
+// State returns the plugins and the resources as seen by NextHealthy
+func (d *Discover) State() State {
+	s := State{
+		Label:     d.Label,
+		Balancing: d.balancing,
+		LocalZone: d.localZone,
+		Panic:     d.InPanic(),
+		Ready:     d.Ready(),
+		Plugins:   make([]PluginState, 0),
+		Resources: make([]ResourceState, 0),
+	}
+	if s.Balancing == "" {
+		s.Balancing = BalancingRoundRobin
+	}
+	if err := d.Health(); err != nil {
+		s.Health = err.Error()
+	}
+
+	sources := d.sources()
+	for _, src := range sources {
+		ps := PluginState{Source: src.uri}
+		if t := atomic.LoadInt64(&src.updated); t > 0 {
+			ps.LastUpdate = time.Unix(0, t)
+		}
+		status := src.plugin.Status()
+		ps.LastSuccess = status.LastSuccess
+		ps.ConsecutiveFailures = status.ConsecutiveFailures
+		if status.LastError != nil {
+			ps.Error = status.LastError.Error()
+			ps.ErrorTime = status.LastErrorTime
+		}
+		s.Plugins = append(s.Plugins, ps)
+	}
+
+	for _, r := range d.Resources() {
+		rs := ResourceState{
+			Host:       r.Host,
+			Protocol:   r.Protocol,
+			Zone:       r.Zone(),
+			Priority:   r.Priority(),
+			Healthy:    r.IsHealthy(),
+			Available:  d.available(r),
+			Ejected:    r.IsEjected(),
+			Circuit:    resource.CircuitStateName(r.CircuitState()),
+			LastUpdate: r.LastUpdate(),
+			InFlight:   r.InFlight(),
+			Weight:     r.Weight(),
+		}
+		rs.Override, rs.OverrideUntil = d.Override(r.Host)
+		for _, src := range sources {
+			if src.plugin == r.Plugin() {
+				rs.Source = src.uri
+			}
+		}
+		if c := r.LastCheck(); !c.Time.IsZero() {
+			rs.LastCheck = c.Time
+			if c.Err != nil {
+				rs.CheckError = c.Err.Error()
+			}
+		}
+		s.Resources = append(s.Resources, rs)
+	}
+	return s
+}
+
+// Handler serves the State as JSON when it is requested with the Accept
+// header or ?format=json, and as an HTML page otherwise
+func (d *Discover) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := d.State()
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			enc.Encode(s)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		adminTemplate.Execute(w, s)
+	})
+}
+
+var adminTemplate = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return time.Since(t).Truncate(time.Millisecond).String() + " ago"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{.Label}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.ok { background: #dfd; }
+.ko { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>{{.Label}}</h1>
+<p>Balancing: {{.Balancing}}{{if .LocalZone}}, local zone: {{.LocalZone}}{{end}}{{if .Panic}}, <strong>panic mode</strong>{{end}}</p>
+<p>Ready: {{.Ready}}{{if .Health}}, <strong>{{.Health}}</strong>{{end}}</p>
+<h2>Plugins</h2>
+<table>
+<tr><th>Source</th><th>Last update</th><th>Last success</th><th>Failures</th><th>Last error</th></tr>
+{{range .Plugins}}<tr class="{{if .ConsecutiveFailures}}ko{{else}}ok{{end}}"><td>{{.Source}}</td><td>{{since .LastUpdate}}</td><td>{{since .LastSuccess}}</td><td>{{.ConsecutiveFailures}}</td><td>{{if .Error}}{{.Error}} ({{since .ErrorTime}}){{end}}</td></tr>
+{{end}}</table>
+<h2>Resources</h2>
+<table>
+<tr><th>Host</th><th>Source</th><th>Zone</th><th>Priority</th><th>Healthy</th><th>Available</th><th>Circuit</th><th>Last update</th><th>Last check</th><th>Check error</th><th>In flight</th><th>Weight</th></tr>
+{{range .Resources}}<tr class="{{if .Available}}ok{{else}}ko{{end}}"><td>{{.Protocol}}://{{.Host}}</td><td>{{.Source}}</td><td>{{.Zone}}</td><td>{{.Priority}}</td><td>{{.Healthy}}</td><td>{{.Available}}{{if .Ejected}} (ejected){{end}}{{if .Override}} ({{.Override}}){{end}}</td><td>{{.Circuit}}</td><td>{{since .LastUpdate}}</td><td>{{since .LastCheck}}</td><td>{{.CheckError}}</td><td>{{.InFlight}}</td><td>{{printf "%.2f" .Weight}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
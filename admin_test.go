@@ -0,0 +1,56 @@
+package discover
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gabrielperezs/discover/resource"
+)
+
+func TestAdminHandler(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	source := "dns://127.0.0.1:80?refresh=10s&zone=eu-west-1a"
+	d := newTestDiscover(t, &Discover{
+		Label:       "test",
+		healthCheck: resource.HealthCheck{URL: "/health", Interval: "20ms"},
+	}, source)
+	d.update([]string{host}, d.sources()[0])
+	eventually(t, func() bool { return !d.Resources()[0].LastCheck().Time.IsZero() })
+
+	w := httptest.NewRecorder()
+	d.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?format=json", nil))
+	var s State
+	if err := json.Unmarshal(w.Body.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Plugins) != 1 || s.Plugins[0].Source != source {
+		t.Errorf("Invalid plugins %+v", s.Plugins)
+	}
+	if len(s.Resources) != 1 {
+		t.Fatalf("Invalid resources %+v", s.Resources)
+	}
+	r := s.Resources[0]
+	if r.Host != host || r.Source != source || r.Zone != "eu-west-1a" || r.Healthy {
+		t.Errorf("Invalid resource %+v", r)
+	}
+	if r.LastCheck.IsZero() || !strings.Contains(r.CheckError, "503") {
+		t.Errorf("Invalid last check %v: %q", r.LastCheck, r.CheckError)
+	}
+
+	w = httptest.NewRecorder()
+	d.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Invalid content type %q", ct)
+	}
+	if !strings.Contains(w.Body.String(), host) {
+		t.Error("Resource not in the HTML page")
+	}
+}
@@ -0,0 +1,182 @@
+package discover
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+
+	"github.com/gabrielperezs/discover/resource"
+)
+
+// overprovisioningFactor of the priority tiers, a tier with 72% of its
+// resources available still gets all the traffic
+const overprovisioningFactor = 1.4
+
+// pick selects with the balancing strategy one of the resources accepted
+// by the filter. The resources in slow start are skipped with the
+// probability of their missing weight, if all of them are skipped the
+// first accepted one is returned
+func (d *Discover) pick(r Resources, filter func(*resource.Resource) bool) *resource.Resource {
+	size := int64(len(r))
+	if size == 0 {
+		return nil
+	}
+
+	var start int64
+	if d.balancing == BalancingRandom {
+		start = rand.Int63n(size)
+	}
+
+	var fallback *resource.Resource
+	for i := int64(0); i < size; i++ {
+		var l *resource.Resource
+		if d.balancing == BalancingRandom {
+			l = r[(start+i)%size]
+		} else {
+			l = r[d.next()%size]
+		}
+		if !filter(l) {
+			continue
+		}
+		if f := l.SlowStartFactor(); f >= 1 || rand.Float64() < f {
+			return l
+		}
+		if fallback == nil {
+			fallback = l
+		}
+	}
+	return fallback
+}
+
+// next is the round-robin counter
+func (d *Discover) next() int64 {
+	n := atomic.AddInt64(&d.n, 1)
+	if n >= math.MaxInt64-1000 {
+		if atomic.CompareAndSwapInt64(&d.n, math.MaxInt64-1000, 0) {
+			n = atomic.AddInt64(&d.n, 1)
+		}
+	}
+	return n
+}
+
+// tierLoads returns the fraction of the traffic for every tier and the
+// total of available resources. A tier gets all the traffic while the
+// available resources multiplied by overprovisioningFactor cover it, the
+// missing part spills over to the next tiers
+func tierLoads(tiers []Resources, filter func(*resource.Resource) bool) (loads []float64, available int) {
+	loads = make([]float64, len(tiers))
+	remaining, total := 1.0, 0.0
+	for i, t := range tiers {
+		n := 0
+		for _, l := range t {
+			if filter(l) {
+				n++
+			}
+		}
+		available += n
+		if len(t) == 0 {
+			continue
+		}
+		health := math.Min(1, overprovisioningFactor*float64(n)/float64(len(t)))
+		loads[i] = math.Min(remaining, health)
+		remaining -= loads[i]
+		total += loads[i]
+	}
+	// Without enough health in all the tiers the load is normalized
+	if total > 0 && total < 1 {
+		for i := range loads {
+			loads[i] /= total
+		}
+	}
+	return loads, available
+}
+
+// chooseTier returns a random tier with the probability of its load
+func chooseTier(loads []float64) int {
+	n := rand.Float64()
+	for i, l := range loads {
+		if n < l {
+			return i
+		}
+		n -= l
+	}
+	return 0
+}
+
+// pickZone prefers the resources of the local zone. Assuming the callers
+// are spread over the zones like the resources, the local zone gets all
+// the traffic while it has at least its share of the available resources,
+// otherwise the missing part goes to the other zones
+func (d *Discover) pickZone(r Resources) *resource.Resource {
+	var local, available int
+	zones := make([]string, 0, 4)
+	for _, l := range r {
+		if !d.available(l) {
+			continue
+		}
+		available++
+		if l.Zone() == d.localZone {
+			local++
+		}
+		if !contains(zones, l.Zone()) {
+			zones = append(zones, l.Zone())
+		}
+	}
+	if available == 0 {
+		return nil
+	}
+
+	share := float64(local) / float64(available) * float64(len(zones))
+	if local > 0 && (share >= 1 || rand.Float64() < share) {
+		if l := d.pick(r, d.inLocalZone); l != nil {
+			d.metrics.get().zoneRouting.WithLabelValues(d.Label, "local").Inc()
+			return l
+		}
+	}
+	if l := d.pick(r, d.inOtherZone); l != nil {
+		d.metrics.get().zoneRouting.WithLabelValues(d.Label, "remote").Inc()
+		return l
+	}
+	return nil
+}
+
+func (d *Discover) inLocalZone(l *resource.Resource) bool {
+	return l.Zone() == d.localZone && d.available(l)
+}
+
+func (d *Discover) inOtherZone(l *resource.Resource) bool {
+	return l.Zone() != d.localZone && d.available(l)
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// inPanic is true when the available resources are below the
+// PanicThreshold, the status is exported in wbrouter_discover_panic
+func (d *Discover) inPanic(available, total int) bool {
+	panic := false
+	if d.panicThreshold > 0 && total > 0 {
+		panic = float64(available)/float64(total) < d.panicThreshold
+	}
+
+	var v int32
+	if panic {
+		v = 1
+	}
+	if atomic.SwapInt32(&d.panic, v) != v {
+		d.metrics.get().panic.WithLabelValues(d.Label).Set(float64(v))
+	}
+	return panic
+}
+
+// InPanic is true when the last NextHealthy ignored the health of the
+// resources because of the PanicThreshold
+func (d *Discover) InPanic() bool {
+	return atomic.LoadInt32(&d.panic) == 1
+}
@@ -1,10 +1,10 @@
 package discover
 
 import (
+	"context"
 	"errors"
-	"math"
+	"net/http"
 	"net/url"
-	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,128 +15,309 @@ import (
 	"github.com/gabrielperezs/discover/pluginK8S"
 	"github.com/gabrielperezs/discover/resource"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
 	exitingDelay   = 10 * time.Second
 	ErrErrorPlugin = errors.New("Unknown plugin")
 
-	statsNoResources = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "wbrouter_discover_no_resources",
-	}, []string{"Label"})
-	statsResources = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "wbrouter_discover_resources",
-	}, []string{"Label"})
-	statsResourcesUnHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "wbrouter_discover_resources_unhealthy",
-	}, []string{"Label"})
+	ErrUnknownBalancing = errors.New("Unknown balancing strategy")
+	ErrNoResources      = errors.New("No resources available")
+	ErrInvalidResource  = errors.New("Invalid resource")
+)
+
+// Balancing strategies used by NextHealthy
+const (
+	BalancingRoundRobin = "roundrobin"
+	BalancingRandom     = "random"
 )
 
 type Config struct {
 	Label       string
 	DiscoverURI []string
 	HealtCheck  resource.HealthCheck
+	Balancing   string
+	// Transport options of the resources, the plugin URIs can
+	// override them
+	Transport discoverlib.TransportOptions
+	// TLS options of the connections to the resources, the plugin URIs
+	// can override them
+	TLS discoverlib.TLSOptions
+	// OutlierDetection ejects the resources that fail with the real
+	// traffic sent with RoundTrip, nil disables it. The requests sent
+	// with the Transport of the resource only report the dial errors
+	OutlierDetection *resource.OutlierDetection
+	// CircuitBreaker of every resource, nil disables it. It only counts
+	// the requests sent with RoundTrip
+	CircuitBreaker *resource.CircuitBreaker
+	// SlowStart ramps up the weight of new and recovered resources, nil
+	// disables it
+	SlowStart *resource.SlowStart
+	// PanicThreshold is the fraction of available resources, like 0.5,
+	// below which the health is ignored and the load is spread over all
+	// the resources. 0 disables it
+	PanicThreshold float64
+	// LocalZone of the caller, the resources of the same zone are
+	// preferred while they have enough capacity. Empty disables it
+	LocalZone string
+	// MinReadyResources is the number of healthy resources WaitReady
+	// waits for, 1 by default
+	MinReadyResources int
+	// Registerer of the metrics, the default Prometheus registry if nil
+	Registerer prometheus.Registerer
+	// MetricsNamespace is the prefix of the metrics, wbrouter by default
+	MetricsNamespace string
+	// TracerProvider of the OpenTelemetry spans, nil disables them. Use
+	// otel.GetTracerProvider() to opt in with the global provider. There
+	// are no OpenTelemetry metrics, they are exported with the Registerer
+	TracerProvider trace.TracerProvider
+	// Logger of the Discover, its plugins and resources, with the label,
+	// plugin and host as fields. Nothing is logged if nil
+	Logger discoverlib.Logger
+	// LenientURI ignores unknown keys and invalid values in DiscoverURI
+	LenientURI bool
+}
+
+// validate returns ConfigErrors with the invalid fields of the options,
+// with the paths used in the File
+func (c Config) validate() error {
+	errs := fieldErrors("health_check", c.HealtCheck.Validate())
+	if c.OutlierDetection != nil {
+		errs = append(errs, fieldErrors("outlier_detection", c.OutlierDetection.Validate())...)
+	}
+	if c.CircuitBreaker != nil {
+		errs = append(errs, fieldErrors("circuit_breaker", c.CircuitBreaker.Validate())...)
+	}
+	if c.SlowStart != nil {
+		errs = append(errs, fieldErrors("slow_start", c.SlowStart.Validate())...)
+	}
+	if err := c.Transport.Validate(); err != nil {
+		errs = append(errs, &ConfigError{Path: "transport", Err: err})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 type Discover struct {
-	Label       string
-	Plugins     []discoverlib.Plugin
-	healthCheck resource.HealthCheck
-	atomicRes   atomic.Value
-	resources   Resources
-	count       int64
-	exit        bool
-	n           int64
+	Label         string
+	sourcesMu     sync.Mutex
+	atomicSources atomic.Value
+	updates       chan sourceUpdate
+	done          chan struct{}
+	// exitingDelay before the resources are removed in the Exit
+	exitingDelay time.Duration
+	// exited is closed at the end of the Exit
+	exited          chan struct{}
+	lenient         bool
+	healthCheck     resource.HealthCheck
+	balancing       string
+	transport       discoverlib.TransportOptions
+	tls             discoverlib.TLSOptions
+	outliers        *resource.OutlierDetector
+	breaker         *resource.CircuitBreaker
+	slowStart       *resource.SlowStart
+	panicThreshold  float64
+	panic           int32
+	localZone       string
+	minReady        int
+	metrics         *metrics
+	resMetrics      *resource.Metrics
+	tracer          trace.Tracer
+	logger          discoverlib.Logger
+	overridesMu     sync.Mutex
+	atomicOverrides atomic.Value
+	atomicRes       atomic.Value
+	atomicTiers     atomic.Value
+	resourcesMu     sync.Mutex
+	resources       Resources
+	count           int64
+	exit            int32
+	n               int64
 }
 
-func New(c Config) *Discover {
+func New(c Config) (*Discover, error) {
+	switch strings.ToLower(c.Balancing) {
+	case "", BalancingRoundRobin, BalancingRandom:
+	default:
+		return nil, ErrUnknownBalancing
+	}
+	if c.PanicThreshold < 0 || c.PanicThreshold > 1 || c.MinReadyResources < 0 {
+		return nil, discoverlib.ErrInvalidValue
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	metrics, err := newMetrics(c.Registerer, c.MetricsNamespace)
+	if err != nil {
+		return nil, err
+	}
+	resMetrics, err := resource.NewMetrics(c.Registerer, c.MetricsNamespace)
+	if err != nil {
+		return nil, err
+	}
+
 	d := &Discover{
-		Label:       c.Label,
-		Plugins:     make([]discoverlib.Plugin, 0),
-		resources:   make(Resources, 0),
-		healthCheck: c.HealtCheck,
+		Label:          c.Label,
+		updates:        make(chan sourceUpdate),
+		done:           make(chan struct{}),
+		exitingDelay:   exitingDelay,
+		exited:         make(chan struct{}),
+		lenient:        c.LenientURI,
+		resources:      make(Resources, 0),
+		healthCheck:    c.HealtCheck,
+		balancing:      strings.ToLower(c.Balancing),
+		transport:      c.Transport,
+		tls:            c.TLS,
+		breaker:        c.CircuitBreaker,
+		slowStart:      c.SlowStart,
+		panicThreshold: c.PanicThreshold,
+		localZone:      c.LocalZone,
+		minReady:       c.MinReadyResources,
+		metrics:        metrics,
+		resMetrics:     resMetrics,
+		logger:         discoverlib.With(c.Logger, "label", c.Label),
 	}
-	d.atomicRes.Store(make(Resources, 0))
+	if c.TracerProvider != nil {
+		d.tracer = c.TracerProvider.Tracer(discoverlib.TracerName)
+	}
+	if c.OutlierDetection != nil {
+		d.outliers = resource.NewOutlierDetector(*c.OutlierDetection)
+	}
+	d.store(make(Resources, 0))
 
-	d.loadPlugins(c.DiscoverURI)
+	if err := d.loadPlugins(c.DiscoverURI, c.LenientURI); err != nil {
+		return nil, err
+	}
+	// The panic gauge is only set when it changes, the new Discover of a
+	// reload starts it again
+	metrics.acquire(d.Label)
+	metrics.panic.WithLabelValues(d.Label).Set(0)
 	go d.listener()
 
-	return d
+	return d, nil
 }
 
+// NextHealthy returns an available resource of the priority tier chosen
+// by its health, nil if there isn't any. Send the requests with its
+// RoundTrip, or with the RoundTrip of the Discover, for the outlier
+// detection and the circuit breaker to see the responses
 func (d *Discover) NextHealthy() *resource.Resource {
 	r := d.Resources()
-	size := len(r)
-	for i := 0; i < size; i++ {
-		n := atomic.AddInt64(&d.n, 1)
-		if n >= math.MaxInt64-1000 {
-			if atomic.CompareAndSwapInt64(&d.n, math.MaxInt64-1000, 0) {
-				n = atomic.AddInt64(&d.n, 1)
-			}
+	tiers := d.tiers()
+	var loads []float64
+	available := -1
+	if len(tiers) > 1 || d.panicThreshold > 0 {
+		loads, available = tierLoads(tiers, d.available)
+	}
+	if d.inPanic(available, len(r)) {
+		if l := d.pick(r, d.usable); l != nil {
+			return l
+		}
+	}
+	tier := r
+	if len(tiers) > 1 {
+		tier = tiers[chooseTier(loads)]
+	}
+	if d.localZone != "" {
+		if l := d.pickZone(tier); l != nil {
+			return l
 		}
-		l := r[n%int64(size)]
-		if l.IsHealthy() {
+	} else if len(tiers) > 1 {
+		if l := d.pick(tier, d.available); l != nil {
 			return l
 		}
 	}
-	statsNoResources.WithLabelValues(d.Label).Add(1)
+	if l := d.pick(r, d.available); l != nil {
+		return l
+	}
+	d.metrics.get().noResources.WithLabelValues(d.Label).Add(1)
 	return nil
 }
 
-func (d *Discover) loadPlugins(uris []string) error {
+// RoundTrip sends the request to the next healthy resource. If the
+// circuit breaker of the resource rejects it another one is tried. The
+// attributes of the chosen resource are added to the span of the request
+func (d *Discover) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := trace.SpanFromContext(req.Context())
+	for i := 0; i <= len(d.Resources()); i++ {
+		r := d.NextHealthy()
+		if r == nil {
+			break
+		}
+		res, err := r.RoundTrip(req)
+		if err == resource.ErrCircuitOpen {
+			span.AddEvent("circuit open", trace.WithAttributes(attribute.String("discover.resource.host", r.Host)))
+			continue
+		}
+		if span.IsRecording() {
+			span.SetAttributes(attribute.String("discover.label", d.Label))
+			span.SetAttributes(r.Attributes()...)
+		}
+		return res, err
+	}
+	span.SetStatus(codes.Error, ErrNoResources.Error())
+	return nil, ErrNoResources
+}
+
+// loadPlugins validates all the URIs before starting any plugin, so
+// nothing is left running if one of them is invalid
+func (d *Discover) loadPlugins(uris []string, lenient bool) error {
+	starts := make([]func() discoverlib.Plugin, 0, len(uris))
 	for _, s := range uris {
-		u, err := url.ParseRequestURI(s)
+		start, err := parsePlugin(s, d.pluginBase(s, lenient))
 		if err != nil {
 			return err
 		}
+		starts = append(starts, start)
+	}
 
-		switch strings.ToLower(u.Scheme) {
-		case "k8s":
-			c := pluginK8S.Config{}
-			if err := c.Load(u); err != nil {
-				return err
-			}
-			d.Plugins = append(d.Plugins, pluginK8S.New(c))
-		case "dns":
-			c := pluginDNS.Config{}
-			if err := c.Load(u); err != nil {
-				return err
-			}
-			d.Plugins = append(d.Plugins, pluginDNS.New(c))
-		default:
-			return ErrErrorPlugin
-		}
+	d.sourcesMu.Lock()
+	defer d.sourcesMu.Unlock()
+	for i, start := range starts {
+		d.add(uris[i], start())
 	}
 	return nil
 }
 
-func (d *Discover) listener() {
-	cases := make([]reflect.SelectCase, len(d.Plugins))
-	for i, p := range d.Plugins {
-		cases[i] = reflect.SelectCase{
-			Dir:  reflect.SelectRecv,
-			Chan: reflect.ValueOf(p.Get()),
-		}
+// pluginBase are the defaults of the plugins of the Discover
+func (d *Discover) pluginBase(s string, lenient bool) discoverlib.ConfigBase {
+	return discoverlib.ConfigBase{
+		Lenient:  lenient,
+		Observer: d.observePlugin(s),
+		Tracer:   d.tracer,
+		Logger:   discoverlib.With(d.logger, "plugin", pluginLabel(s)),
 	}
+}
 
-	remaining := len(cases)
-	for remaining > 0 {
-		chosen, value, ok := reflect.Select(cases)
-		if !ok {
-			// The chosen channel has been closed, so zero out the channel to disable the case
-			cases[chosen].Chan = reflect.ValueOf(nil)
-			remaining--
-			continue
-		}
-
-		d.stats()
+// parsePlugin loads the plugin configuration from the URI, with base as
+// defaults, and returns the function that starts it, so URIs can be
+// validated without side effects
+func parsePlugin(s string, base discoverlib.ConfigBase) (func() discoverlib.Plugin, error) {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return nil, err
+	}
 
-		slice, ok := value.Interface().([]string)
-		if !ok {
-			continue
+	switch strings.ToLower(strings.SplitN(u.Scheme, "+", 2)[0]) {
+	case "k8s":
+		c := pluginK8S.Config{ConfigBase: base}
+		if err := c.Load(u); err != nil {
+			return nil, err
+		}
+		return func() discoverlib.Plugin { return pluginK8S.New(c) }, nil
+	case "dns":
+		c := pluginDNS.Config{ConfigBase: base}
+		if err := c.Load(u); err != nil {
+			return nil, err
 		}
-		d.update(slice, chosen)
+		return func() discoverlib.Plugin { return pluginDNS.New(c) }, nil
+	default:
+		return nil, &discoverlib.URIError{URI: s, Key: "scheme", Value: u.Scheme, Err: ErrErrorPlugin}
 	}
 }
 
@@ -148,8 +329,26 @@ func (d *Discover) Resources() Resources {
 	return d.atomicRes.Load().(Resources)
 }
 
+// tiers are the resources grouped by priority, the highest first
+func (d *Discover) tiers() []Resources {
+	if t, ok := d.atomicTiers.Load().([]Resources); ok {
+		return t
+	}
+	return []Resources{d.Resources()}
+}
+
+func (d *Discover) store(r Resources) {
+	d.atomicTiers.Store(r.tiers())
+	d.atomicRes.Store(r)
+	atomic.StoreInt64(&d.count, int64(len(r)))
+}
+
+// Exit stops the plugins and closes the resources in background, the
+// next calls do nothing
 func (d *Discover) Exit() {
-	d.exit = true
+	if !atomic.CompareAndSwapInt32(&d.exit, 0, 1) {
+		return
+	}
 	go d.lazyExit()
 }
 
@@ -162,36 +361,107 @@ func (d *Discover) stats() {
 			unhealthy++
 		}
 	}
-	statsResources.WithLabelValues(d.Label).Set(healthy)
-	statsResourcesUnHealthy.WithLabelValues(d.Label).Set(unhealthy)
+	m := d.metrics.get()
+	m.resources.WithLabelValues(d.Label).Set(healthy)
+	m.resourcesUnhealthy.WithLabelValues(d.Label).Set(unhealthy)
 }
 
+// lazyExit removes the sources before closing the resources, so the
+// updates still in the listener are ignored, then it stops the listener
 func (d *Discover) lazyExit() {
-	wg := &sync.WaitGroup{}
-	for _, p := range d.Plugins {
-		wg.Add(1)
-		go func(p discoverlib.Plugin) {
-			p.Exit()
-			wg.Done()
-		}(p)
-	}
-	wg.Wait()
+	sources := d.stopSources()
+
 	// Clean local resources
+	d.resourcesMu.Lock()
+	for _, s := range sources {
+		s.removed = true
+	}
 	for i, r := range d.resources {
 		r.Close()
 		d.resources[i] = nil
 	}
 	d.resources = d.resources[:0]
-	time.Sleep(exitingDelay)
-	d.atomicRes.Store(make(Resources, 0))
+	d.resourcesMu.Unlock()
+
+	d.exitPlugins(sources)
+	if d.done != nil {
+		close(d.done)
+	}
+
+	time.Sleep(d.exitingDelay)
+	d.store(make(Resources, 0))
+	// Other instances with the same Label keep their series, e.g. the new
+	// Discover of a reload
+	m := d.metrics.get()
+	for _, s := range sources {
+		m.releasePlugin(d.Label, s.uri)
+	}
+	m.release(d.Label)
+	if d.exited != nil {
+		close(d.exited)
+	}
+}
+
+// update creates and expires the resources with the hosts of the source
+func (d *Discover) update(slice []string, s *source) {
+	d.resourcesMu.Lock()
+	defer d.resourcesMu.Unlock()
+	if s.removed {
+		return
+	}
+
+	_, span := discoverlib.Tracer(d.tracer).Start(context.Background(), "discover.plugin.update",
+		trace.WithAttributes(
+			attribute.String("discover.label", d.Label),
+			attribute.String("discover.plugin", pluginLabel(s.uri)),
+			attribute.Int("discover.plugin.hosts", len(slice)),
+		))
+	defer span.End()
+
+	added, removed, err := d.resources.update(s.plugin, slice, resource.Config{
+		HealthCheck:    d.healthCheck,
+		Transport:      d.transport,
+		TLS:            d.tls,
+		Outliers:       d.outliers,
+		CircuitBreaker: d.breaker,
+		SlowStart:      d.slowStart,
+		Metrics:        d.resMetrics,
+		Label:          d.Label,
+		Tracer:         d.tracer,
+		Logger:         discoverlib.With(d.logger, "plugin", pluginLabel(s.uri)),
+	})
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.SetAttributes(
+		attribute.Int("discover.resources.added", added),
+		attribute.Int("discover.resources.removed", removed),
+	)
+	d.publish(added, removed)
+}
+
+// remove closes the resources created by the plugin of the source and
+// ignores its next updates
+func (d *Discover) remove(s *source) {
+	d.resourcesMu.Lock()
+	defer d.resourcesMu.Unlock()
+	s.removed = true
+	removed := d.resources.remove(s.plugin, discoverlib.With(d.logger, "plugin", pluginLabel(s.uri)))
+	d.publish(0, removed)
 }
 
-func (d *Discover) update(slice []string, chosen int) {
-	if d.resources.update(d.Plugins[chosen], slice, d.healthCheck) {
-		r := d.resources.clone()
-		d.atomicRes.Store(r)
-		atomic.StoreInt64(&d.count, int64(len(r)))
-		statsResources.WithLabelValues(d.Label).Set(float64(len(r)))
-		d.resources.clean()
+// publish stores the resources after the changes of update or remove. It
+// must be called with resourcesMu locked
+func (d *Discover) publish(added, removed int) {
+	if added+removed == 0 {
+		return
 	}
+	m := d.metrics.get()
+	m.resourcesAdded.WithLabelValues(d.Label).Add(float64(added))
+	m.resourcesRemoved.WithLabelValues(d.Label).Add(float64(removed))
+
+	d.resources.clean()
+	r := d.resources.clone()
+	d.store(r)
+	m.resources.WithLabelValues(d.Label).Set(float64(len(r)))
 }
@@ -1,9 +1,21 @@
 package discover
 
 import (
+	"errors"
+	"math"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+	"github.com/gabrielperezs/discover/resource"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestLoadPlugins(t *testing.T) {
@@ -14,9 +26,48 @@ func TestLoadPlugins(t *testing.T) {
 			"dns://www.dotwconnect.com:80?refresh=5s",
 		},
 	}
-	err := d.loadPlugins(c.DiscoverURI)
-	if err != nil {
-		t.Error(err)
+	newTestDiscover(t, d, c.DiscoverURI...)
+}
+
+func TestLoadPluginsInvalid(t *testing.T) {
+	d := &Discover{}
+	err := d.loadPlugins([]string{
+		"dns://1.1.1.1:80?refresh=10s",
+		"dns://1.1.1.2:80?refresh=often",
+	}, false)
+	var e *discoverlib.URIError
+	if !errors.As(err, &e) || e.Key != "refresh" {
+		t.Errorf("Expected URIError for refresh, got %v", err)
+	}
+	if len(d.Plugins()) != 0 {
+		t.Errorf("Plugins started with an invalid URI: %d", len(d.Plugins()))
+	}
+
+	if err := d.loadPlugins([]string{"ftp://1.1.1.1:80"}, false); !errors.Is(err, ErrErrorPlugin) {
+		t.Errorf("Expected ErrErrorPlugin, got %v", err)
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	d, err := New(Config{
+		Label:          "test",
+		DiscoverURI:    []string{"dns://127.0.0.1:80?refresh=10s"},
+		HealtCheck:     resource.HealthCheck{Type: "bogus", Interval: "soon"},
+		CircuitBreaker: &resource.CircuitBreaker{FailureRatio: 7},
+		Transport:      discoverlib.TransportOptions{DialTimeout: -time.Second},
+		Registerer:     prometheus.NewRegistry(),
+	})
+	var errs ConfigErrors
+	if !errors.As(err, &errs) || d != nil {
+		t.Fatalf("Expected ConfigErrors, got %v", err)
+	}
+	paths := make([]string, len(errs))
+	for i, e := range errs {
+		paths[i] = e.Path
+	}
+	expected := []string{"health_check.interval", "health_check.type", "circuit_breaker.failure_ratio", "transport"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Invalid paths %v", paths)
 	}
 }
 
@@ -29,17 +80,14 @@ func TestStoreAndLoad(t *testing.T) {
 			"dns://1.1.1.3:80?refresh=10s",
 		},
 	}
-	err := d.loadPlugins(c.DiscoverURI)
-	if err != nil {
-		t.Error(err)
-	}
+	newTestDiscover(t, d, c.DiscoverURI...)
 
 	hosts := []string{
 		"1.1.1.1",
 		"1.1.1.2",
 		"1.1.1.3",
 	}
-	d.update(hosts, 0)
+	d.update(hosts, d.sources()[0])
 
 	if len(hosts) != len(d.Resources()) {
 		t.Error("Invalid number of hosts")
@@ -65,17 +113,14 @@ func TestNextResource(t *testing.T) {
 			"dns://1.1.1.3:80?refresh=10s",
 		},
 	}
-	err := d.loadPlugins(c.DiscoverURI)
-	if err != nil {
-		t.Error(err)
-	}
+	newTestDiscover(t, d, c.DiscoverURI...)
 
 	hosts := []string{
 		"1.1.1.1",
 		"1.1.1.2",
 		"1.1.1.3",
 	}
-	d.update(hosts, 0)
+	d.update(hosts, d.sources()[0])
 
 	for i, v := range d.Resources() {
 		t.Logf("Resource: %d - %v", i, v.Host)
@@ -93,6 +138,8 @@ func TestNextResource(t *testing.T) {
 func TestTickerPlugins(t *testing.T) {
 	d := &Discover{
 		resources: make(Resources, 0),
+		updates:   make(chan sourceUpdate),
+		done:      make(chan struct{}),
 	}
 	d.atomicRes.Store(make(Resources, 0))
 
@@ -103,7 +150,7 @@ func TestTickerPlugins(t *testing.T) {
 			"dns://1a7f6e769243af4202942ae498c376a51-1588922734.eu-west-1.elb.amazonaws.com:80?refresh=1s",
 		},
 	}
-	err := d.loadPlugins(c.DiscoverURI)
+	err := d.loadPlugins(c.DiscoverURI, false)
 	if err != nil {
 		t.Error(err)
 	}
@@ -118,3 +165,258 @@ func TestTickerPlugins(t *testing.T) {
 
 	d.listener()
 }
+
+func TestRoundTripCircuitBreaker(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer good.Close()
+
+	d := newTestDiscover(t, &Discover{
+		breaker: &resource.CircuitBreaker{MinRequests: 1},
+	})
+	d.update([]string{
+		strings.TrimPrefix(bad.URL, "http://"),
+		strings.TrimPrefix(good.URL, "http://"),
+	}, d.sources()[0])
+
+	failed := 0
+	for i := 0; i < 10; i++ {
+		req, _ := http.NewRequest(http.MethodGet, good.URL, nil)
+		res, err := d.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("Expected only one failed request, got %d", failed)
+	}
+}
+
+func TestPanicThreshold(t *testing.T) {
+	hosts := make([]string, 3)
+	for i := range hosts {
+		status := http.StatusInternalServerError
+		if i == 0 {
+			status = http.StatusOK
+		}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+		hosts[i] = strings.TrimPrefix(srv.URL, "http://")
+	}
+
+	for _, threshold := range []float64{0, 0.5} {
+		d := newTestDiscover(t, &Discover{
+			healthCheck:    resource.HealthCheck{URL: "/", Interval: "20ms"},
+			panicThreshold: threshold,
+		})
+		d.update(hosts, d.sources()[0])
+
+		eventually(t, func() bool {
+			healthy := 0
+			for _, r := range d.Resources() {
+				if r.IsHealthy() {
+					healthy++
+				}
+			}
+			return healthy == 1
+		})
+
+		used := make(map[string]bool)
+		for i := 0; i < 30; i++ {
+			used[d.NextHealthy().Host] = true
+		}
+		if threshold == 0 && (len(used) != 1 || d.InPanic()) {
+			t.Errorf("Unhealthy resources used without panic threshold: %v", used)
+		}
+		if threshold > 0 && (len(used) != 3 || !d.InPanic()) {
+			t.Errorf("Not all the resources used in panic mode: %v", used)
+		}
+	}
+}
+
+func TestPriorityTiers(t *testing.T) {
+	listen := func() string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ln.Close() })
+		return ln.Addr().String()
+	}
+	closed := func() string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln.Close()
+		return ln.Addr().String()
+	}
+
+	d := newTestDiscover(t, &Discover{
+		healthCheck: resource.HealthCheck{Type: resource.HealthCheckTCP, Interval: "20ms", Timeout: "100ms"},
+	}, "dns://127.0.0.1:80?refresh=10s", "dns://127.0.0.2:80?refresh=10s&priority=1")
+	d.update([]string{listen(), listen(), closed(), closed()}, d.sources()[0])
+	d.update([]string{listen()}, d.sources()[1])
+
+	eventually(t, func() bool {
+		_, available := tierLoads(d.tiers(), d.available)
+		return available == 3
+	})
+
+	// 50% available with the overprovisioning is 70% of the traffic
+	loads, _ := tierLoads(d.tiers(), d.available)
+	if len(loads) != 2 || math.Abs(loads[0]-0.7) > 0.001 || math.Abs(loads[1]-0.3) > 0.001 {
+		t.Fatalf("Invalid loads %v", loads)
+	}
+
+	spilled := 0
+	for i := 0; i < 1000; i++ {
+		if d.NextHealthy().Priority() == 1 {
+			spilled++
+		}
+	}
+	if spilled < 200 || spilled > 400 {
+		t.Errorf("Expected ~300 requests to the priority 1, got %d", spilled)
+	}
+}
+
+func TestZoneAwareRouting(t *testing.T) {
+	tests := []struct {
+		local, remote []string
+		min, max      int
+	}{
+		// The local zone has its share of the resources
+		{[]string{"10.0.0.1:80", "10.0.0.2:80"}, []string{"10.0.1.1:80", "10.0.1.2:80"}, 1000, 1000},
+		// Half of its share, the other half spills over
+		{[]string{"10.0.0.1:80"}, []string{"10.0.1.1:80", "10.0.1.2:80", "10.0.1.3:80"}, 400, 600},
+		{nil, []string{"10.0.1.1:80"}, 0, 0},
+	}
+	for _, tt := range tests {
+		d := newTestDiscover(t, &Discover{localZone: "eu-west-1a"},
+			"dns://127.0.0.1:80?refresh=10s&zone=eu-west-1a",
+			"dns://127.0.0.2:80?refresh=10s&zone=eu-west-1b")
+		d.update(tt.local, d.sources()[0])
+		d.update(tt.remote, d.sources()[1])
+
+		local := 0
+		for i := 0; i < 1000; i++ {
+			if d.NextHealthy().Zone() == "eu-west-1a" {
+				local++
+			}
+		}
+		if local < tt.min || local > tt.max {
+			t.Errorf("Expected between %d and %d local requests, got %d", tt.min, tt.max, local)
+		}
+	}
+}
+
+func TestNewRegisterer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	for i := 0; i < 2; i++ {
+		d, err := New(Config{
+			Label:            "test",
+			DiscoverURI:      []string{"dns://127.0.0.1:80?refresh=10s"},
+			Registerer:       reg,
+			MetricsNamespace: "test",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Exit()
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if !strings.HasPrefix(f.GetName(), "test_") {
+			t.Errorf("Metric without namespace %s", f.GetName())
+		}
+	}
+}
+
+func TestNewRegistererCollision(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "test",
+		Name:      "discover_resources",
+	}, []string{"Label"}))
+	d, err := New(Config{
+		Label:            "test",
+		DiscoverURI:      []string{"dns://127.0.0.1:80?refresh=10s"},
+		Registerer:       reg,
+		MetricsNamespace: "test",
+	})
+	if !errors.Is(err, resource.ErrMetricType) || d != nil {
+		t.Errorf("Expected ErrMetricType, got %v", err)
+	}
+}
+
+func TestPluginMetrics(t *testing.T) {
+	d, err := New(Config{
+		Label:       "test",
+		DiscoverURI: []string{"dns://127.0.0.1:80?refresh=10s"},
+		Registerer:  prometheus.NewRegistry(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Exit()
+
+	eventually(t, func() bool { return d.Len() > 0 })
+	m := d.metrics
+	if v := testutil.ToFloat64(m.pluginUpdates.WithLabelValues("test", "dns://127.0.0.1:80", "success")); v != 1 {
+		t.Errorf("Invalid plugin updates %v", v)
+	}
+	if v := testutil.ToFloat64(m.pluginLastSuccess.WithLabelValues("test", "dns://127.0.0.1:80")); v == 0 {
+		t.Error("Last success not set")
+	}
+	if v := testutil.ToFloat64(m.resourcesAdded.WithLabelValues("test")); v != 1 {
+		t.Errorf("Invalid resources added %v", v)
+	}
+}
+
+// newTestDiscover starts the plugins of the URIs, a DNS source by default,
+// in d without the listener, the tests apply the updates with update. The
+// plugins are stopped and the resources closed at the end of the test
+func newTestDiscover(t *testing.T, d *Discover, uris ...string) *Discover {
+	t.Helper()
+	if len(uris) == 0 {
+		uris = []string{"dns://127.0.0.1:80?refresh=10s"}
+	}
+	d.store(make(Resources, 0))
+	if err := d.loadPlugins(uris, false); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		d.exitPlugins(d.stopSources())
+		d.resourcesMu.Lock()
+		defer d.resourcesMu.Unlock()
+		for _, r := range d.resources {
+			r.Close()
+		}
+	})
+	return d
+}
+
+// eventually waits up to a second for cond
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met after a second")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1,7 +1,21 @@
 package discoverlib
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrUnknownKey = errors.New("Unknown key")
+	// ErrInvalidValue of the URIs and of the options of the Discover and
+	// the resources
+	ErrInvalidValue = errors.New("Invalid value")
 )
 
 type ConfigBase struct {
@@ -9,6 +23,105 @@ type ConfigBase struct {
 	Port     string
 	Protocol string
 	Weight   int64
-	Refresh  time.Duration
-	Timeout  time.Duration
+	// Priority of the resources of the plugin, 0 is the highest. The
+	// lower priorities only get traffic when the higher ones degrade
+	Priority int
+	// Zone of the resources of the plugin, used by the zone-aware
+	// routing when the plugin doesn't know the zone of every host
+	Zone    string
+	Refresh time.Duration
+	// Transport overrides the TransportOptions of the Discover for the
+	// resources of this plugin
+	Transport TransportOptions
+	// TLS overrides the TLSOptions of the Discover
+	TLS TLSOptions
+	// Lenient ignores, with a warning, the unknown keys and invalid
+	// values of the URI instead of returning an URIError
+	Lenient bool
+	// Observer is called with the result of every update of the plugin
+	Observer func(err error)
+	// Tracer of the lookups of the plugin, no tracing if nil
+	Tracer trace.Tracer
+	// Logger of the plugin, nothing is logged if nil
+	Logger Logger
+}
+
+// URIError is returned when a key of the plugin URI is unknown or
+// its value can't be parsed
+type URIError struct {
+	URI   string
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *URIError) Error() string {
+	return fmt.Sprintf("%s: %s=%q: %s", e.URI, e.Key, e.Value, e.Err)
+}
+
+func (e *URIError) Unwrap() error {
+	return e.Err
+}
+
+// Set parses the keys shared by all the plugins, known is false if
+// the key doesn't belong to ConfigBase. Invalid values are not assigned,
+// so in Lenient mode the previous value is kept
+func (c *ConfigBase) Set(key, value string) (known bool, err error) {
+	switch strings.ToLower(key) {
+	case "refresh":
+		err = setDuration(&c.Refresh, value)
+	case "weight":
+		var w int64
+		if w, err = strconv.ParseInt(value, 10, 64); err == nil && w < 0 {
+			err = ErrInvalidValue
+		}
+		if err == nil {
+			c.Weight = w
+		}
+	case "zone":
+		c.Zone = value
+	case "priority":
+		err = setInt(&c.Priority, value)
+	default:
+		if known, err = c.Transport.Set(key, value); known {
+			return known, err
+		}
+		return c.TLS.Set(key, value)
+	}
+	return true, err
+}
+
+// Check returns an URIError for the key if err is not nil. In Lenient
+// mode the error is logged and nil is returned
+func (c *ConfigBase) Check(u *url.URL, key, value string, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &URIError{URI: u.String(), Key: key, Value: value, Err: err}
+	if c.Lenient {
+		With(c.Logger).Warn("ignoring invalid URI parameter", "uri", e.URI, "key", key, "value", value, "error", err)
+		return nil
+	}
+	return e
+}
+
+// LoadProtocol takes the protocol from schemes like "dns+https"
+func (c *ConfigBase) LoadProtocol(u *url.URL) {
+	for i, s := range strings.Split(u.Scheme, "+") {
+		if i == 1 {
+			c.Protocol = s
+		}
+	}
+}
+
+// setDuration assigns the value to dst if it is a valid positive duration
+func setDuration(dst *time.Duration, value string) error {
+	d, err := time.ParseDuration(value)
+	if err == nil && d < 0 {
+		err = ErrInvalidValue
+	}
+	if err == nil {
+		*dst = d
+	}
+	return err
 }
@@ -0,0 +1,45 @@
+package discoverlib
+
+// Logger of discover, the args are key and value pairs. It is
+// implemented by *slog.Logger
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger discards everything, it is the default
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+
+// With returns a Logger that adds args to every message, l can be nil
+func With(l Logger, args ...interface{}) Logger {
+	switch v := l.(type) {
+	case nil, nopLogger:
+		return NopLogger
+	case withLogger:
+		return withLogger{l: v.l, args: append(v.args[:len(v.args):len(v.args)], args...)}
+	}
+	return withLogger{l: l, args: args}
+}
+
+type withLogger struct {
+	l    Logger
+	args []interface{}
+}
+
+func (w withLogger) with(args []interface{}) []interface{} {
+	return append(w.args[:len(w.args):len(w.args)], args...)
+}
+
+func (w withLogger) Debug(msg string, args ...interface{}) { w.l.Debug(msg, w.with(args)...) }
+func (w withLogger) Info(msg string, args ...interface{})  { w.l.Info(msg, w.with(args)...) }
+func (w withLogger) Warn(msg string, args ...interface{})  { w.l.Warn(msg, w.with(args)...) }
+func (w withLogger) Error(msg string, args ...interface{}) { w.l.Error(msg, w.with(args)...) }
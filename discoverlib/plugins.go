@@ -1,11 +1,16 @@
 package discoverlib
 
-import "time"
-
 type Plugin interface {
 	Get() chan []string
 	Protocol() string
 	Weight() int64
-	Timeout() time.Duration
+	Priority() int
+	// Zone returns the locality of one of the hosts sent by Get
+	Zone(host string) string
+	Transport() TransportOptions
+	TLS() TLSOptions
+	Hostname() string
+	// Status of the updates of the plugin
+	Status() Status
 	Exit()
 }
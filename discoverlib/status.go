@@ -0,0 +1,61 @@
+package discoverlib
+
+import (
+	"sync"
+	"time"
+)
+
+// Status of the updates of a plugin
+type Status struct {
+	// LastSuccess is the time of the last update without error
+	LastSuccess time.Time
+	// LastError of the updates, it is kept after a success
+	LastError     error
+	LastErrorTime time.Time
+	// ConsecutiveFailures since the last success
+	ConsecutiveFailures int
+}
+
+// Failing is true when the last update failed
+func (s Status) Failing() bool {
+	return s.ConsecutiveFailures > 0
+}
+
+// StatusRecorder keeps the Status of a plugin, embedding it implements
+// Status of the Plugin interface
+type StatusRecorder struct {
+	mu       sync.Mutex
+	status   Status
+	observer func(error)
+}
+
+// SetObserver sets the function called by SetError, usually the Observer
+// of the ConfigBase
+func (s *StatusRecorder) SetObserver(f func(error)) {
+	s.observer = f
+}
+
+// SetError records the result of an update, nil when it succeeded
+func (s *StatusRecorder) SetError(err error) {
+	s.mu.Lock()
+	now := time.Now()
+	if err == nil {
+		s.status.LastSuccess = now
+		s.status.ConsecutiveFailures = 0
+	} else {
+		s.status.LastError = err
+		s.status.LastErrorTime = now
+		s.status.ConsecutiveFailures++
+	}
+	s.mu.Unlock()
+	if s.observer != nil {
+		s.observer(err)
+	}
+}
+
+// Status returns a copy of the current Status
+func (s *StatusRecorder) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -0,0 +1,89 @@
+package discoverlib
+
+import (
+	"crypto/tls"
+	"strconv"
+	"strings"
+)
+
+// TLSOptions configures the TLS connections to the resources
+type TLSOptions struct {
+	// CAFile is a PEM bundle used instead of the system roots
+	CAFile string
+	// CertFile and KeyFile are the client certificate for mTLS
+	CertFile string
+	KeyFile  string
+	// ServerName expected in the certificate, by default the hostname
+	// of the plugin if it is not an IP
+	ServerName string
+	// MinVersion like "1.2" or "1.3", by default TLS 1.2
+	MinVersion         string
+	InsecureSkipVerify bool
+}
+
+// Set parses the TLS keys used in the plugin URIs, known is false
+// if the key is not a TLS option
+func (o *TLSOptions) Set(key, value string) (known bool, err error) {
+	switch strings.ToLower(key) {
+	case "tls_ca":
+		o.CAFile = value
+	case "tls_cert":
+		o.CertFile = value
+	case "tls_key":
+		o.KeyFile = value
+	case "tls_server_name":
+		o.ServerName = value
+	case "tls_min_version":
+		if _, err = ParseTLSVersion(value); err == nil {
+			o.MinVersion = value
+		}
+	case "tls_insecure":
+		var b bool
+		if b, err = strconv.ParseBool(value); err == nil {
+			o.InsecureSkipVerify = b
+		}
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// Merge returns a copy of the options where the non zero values of
+// override replace the current ones
+func (o TLSOptions) Merge(override TLSOptions) TLSOptions {
+	if override.CAFile != "" {
+		o.CAFile = override.CAFile
+	}
+	if override.CertFile != "" {
+		o.CertFile = override.CertFile
+	}
+	if override.KeyFile != "" {
+		o.KeyFile = override.KeyFile
+	}
+	if override.ServerName != "" {
+		o.ServerName = override.ServerName
+	}
+	if override.MinVersion != "" {
+		o.MinVersion = override.MinVersion
+	}
+	if override.InsecureSkipVerify {
+		o.InsecureSkipVerify = true
+	}
+	return o
+}
+
+// ParseTLSVersion converts versions like "1.2" to the tls constants,
+// an empty string is TLS 1.2
+func ParseTLSVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, ErrInvalidValue
+}
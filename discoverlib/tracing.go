@@ -0,0 +1,36 @@
+package discoverlib
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName of the OpenTelemetry spans created by discover. Only the
+// spans use OpenTelemetry, the metrics are Prometheus collectors
+const TracerName = "github.com/gabrielperezs/discover"
+
+// Tracer returns t or a no-op tracer when it is nil. The global provider
+// is not used, the tracing is enabled only with an explicit tracer
+func Tracer(t trace.Tracer) trace.Tracer {
+	if t == nil {
+		return trace.NewNoopTracerProvider().Tracer(TracerName)
+	}
+	return t
+}
+
+// StartSpan starts a span with the Tracer of the plugin
+func (c *ConfigBase) StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer(c.Tracer).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan records the error, if any, and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
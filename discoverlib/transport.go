@@ -0,0 +1,129 @@
+package discoverlib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransportOptions configures the http.Transport and the dialer of every
+// resource. Zero values use the defaults of the resource package
+type TransportOptions struct {
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	IdleConnTimeout       time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ExpectContinueTimeout time.Duration
+	ResponseHeaderTimeout time.Duration
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	// HTTP2 enables HTTP/2 when it is negotiated with TLS, nil keeps
+	// the default (disabled)
+	HTTP2 *bool
+}
+
+// Set parses the transport keys used in the plugin URIs, known is false
+// if the key is not a transport option
+func (o *TransportOptions) Set(key, value string) (known bool, err error) {
+	switch strings.ToLower(key) {
+	case "dial_timeout":
+		err = setDuration(&o.DialTimeout, value)
+	case "keepalive":
+		err = setDuration(&o.KeepAlive, value)
+	case "idle_conn_timeout":
+		err = setDuration(&o.IdleConnTimeout, value)
+	case "tls_handshake_timeout":
+		err = setDuration(&o.TLSHandshakeTimeout, value)
+	case "expect_continue_timeout":
+		err = setDuration(&o.ExpectContinueTimeout, value)
+	case "response_header_timeout", "timeout":
+		err = setDuration(&o.ResponseHeaderTimeout, value)
+	case "max_idle_conns":
+		err = setInt(&o.MaxIdleConns, value)
+	case "max_idle_conns_per_host":
+		err = setInt(&o.MaxIdleConnsPerHost, value)
+	case "max_conns_per_host":
+		err = setInt(&o.MaxConnsPerHost, value)
+	case "http2":
+		var b bool
+		if b, err = strconv.ParseBool(value); err == nil {
+			o.HTTP2 = &b
+		}
+	default:
+		return false, nil
+	}
+	return true, err
+}
+
+// Validate returns an error with the first negative option
+func (o TransportOptions) Validate() error {
+	for _, v := range []struct {
+		key   string
+		value int64
+	}{
+		{"dial_timeout", int64(o.DialTimeout)},
+		{"keepalive", int64(o.KeepAlive)},
+		{"idle_conn_timeout", int64(o.IdleConnTimeout)},
+		{"tls_handshake_timeout", int64(o.TLSHandshakeTimeout)},
+		{"expect_continue_timeout", int64(o.ExpectContinueTimeout)},
+		{"response_header_timeout", int64(o.ResponseHeaderTimeout)},
+		{"max_idle_conns", int64(o.MaxIdleConns)},
+		{"max_idle_conns_per_host", int64(o.MaxIdleConnsPerHost)},
+		{"max_conns_per_host", int64(o.MaxConnsPerHost)},
+	} {
+		if v.value < 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidValue, v.key)
+		}
+	}
+	return nil
+}
+
+// Merge returns a copy of the options where the non zero values of
+// override replace the current ones
+func (o TransportOptions) Merge(override TransportOptions) TransportOptions {
+	if override.DialTimeout > 0 {
+		o.DialTimeout = override.DialTimeout
+	}
+	if override.KeepAlive > 0 {
+		o.KeepAlive = override.KeepAlive
+	}
+	if override.IdleConnTimeout > 0 {
+		o.IdleConnTimeout = override.IdleConnTimeout
+	}
+	if override.TLSHandshakeTimeout > 0 {
+		o.TLSHandshakeTimeout = override.TLSHandshakeTimeout
+	}
+	if override.ExpectContinueTimeout > 0 {
+		o.ExpectContinueTimeout = override.ExpectContinueTimeout
+	}
+	if override.ResponseHeaderTimeout > 0 {
+		o.ResponseHeaderTimeout = override.ResponseHeaderTimeout
+	}
+	if override.MaxIdleConns > 0 {
+		o.MaxIdleConns = override.MaxIdleConns
+	}
+	if override.MaxIdleConnsPerHost > 0 {
+		o.MaxIdleConnsPerHost = override.MaxIdleConnsPerHost
+	}
+	if override.MaxConnsPerHost > 0 {
+		o.MaxConnsPerHost = override.MaxConnsPerHost
+	}
+	if override.HTTP2 != nil {
+		o.HTTP2 = override.HTTP2
+	}
+	return o
+}
+
+// setInt assigns the value to dst if it is a valid positive integer
+func setInt(dst *int, value string) error {
+	i, err := strconv.Atoi(value)
+	if err == nil && i < 0 {
+		err = ErrInvalidValue
+	}
+	if err == nil {
+		*dst = i
+	}
+	return err
+}
@@ -0,0 +1,432 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+	"github.com/gabrielperezs/discover/resource"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	ErrNoSources = errors.New("At least one source is required")
+)
+
+// File is the declarative configuration of many Discover instances. It can
+// be written in YAML or JSON:
+//
+//	backends:
+//	  api:
+//	    sources:
+//	      - k8s://kubeconfig:80?namespace=api
+//	      - dns://api.example.com:80
+//	    balancing: random
+//	    timeout: 30s
+//	    transport:
+//	      dial_timeout: 2s
+//	      max_conns_per_host: 50
+//	    tls:
+//	      ca: /etc/ssl/api-ca.pem
+//	      server_name: api.internal
+//	    health_check:
+//	      url: http://api/health
+//	      resp_code: 200
+//	      interval: 5s
+type File struct {
+	Backends map[string]Backend `json:"backends"`
+}
+
+// Backend is the configuration of one Discover instance, the name of
+// the backend in the File is used as Label
+type Backend struct {
+	Sources     []string             `json:"sources"`
+	Balancing   string               `json:"balancing,omitempty"`
+	Timeout     Duration             `json:"timeout,omitempty"`
+	LenientURI  bool                 `json:"lenient_uri,omitempty"`
+	HealthCheck resource.HealthCheck `json:"health_check,omitempty"`
+	// OutlierDetection enables the passive health checks
+	OutlierDetection  *resource.OutlierDetection `json:"outlier_detection,omitempty"`
+	CircuitBreaker    *resource.CircuitBreaker   `json:"circuit_breaker,omitempty"`
+	SlowStart         *resource.SlowStart        `json:"slow_start,omitempty"`
+	PanicThreshold    float64                    `json:"panic_threshold,omitempty"`
+	LocalZone         string                     `json:"local_zone,omitempty"`
+	MinReadyResources int                        `json:"min_ready_resources,omitempty"`
+	// Transport uses the same keys as the plugin URIs, like
+	// dial_timeout or max_conns_per_host
+	Transport map[string]interface{} `json:"transport,omitempty"`
+	// TLS uses the keys of the plugin URIs without the "tls_" prefix,
+	// like ca, cert, key, server_name or min_version
+	TLS map[string]interface{} `json:"tls,omitempty"`
+}
+
+// Duration is a time.Duration written as a string like "1m30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	s, err := strconv.Unquote(string(b))
+	if err != nil {
+		return fmt.Errorf("%w: duration must be a string like \"5s\"", discoverlib.ErrInvalidValue)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Duration(d).String())), nil
+}
+
+// ConfigError is a validation error of the File, Path is the field
+// that contains the invalid value, like "backends.api.sources[1]"
+type ConfigError struct {
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors contains all the validation errors found in a File
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// ParseFile decodes a YAML or JSON configuration and validates it
+func ParseFile(b []byte) (*File, error) {
+	f := &File{}
+	if err := yaml.UnmarshalStrict(b, f); err != nil {
+		return nil, err
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReadFile reads and parses the configuration from the path
+func ReadFile(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFile(b)
+}
+
+// Validate returns ConfigErrors with all the invalid fields
+func (f *File) Validate() error {
+	var errs ConfigErrors
+	for _, name := range f.names() {
+		errs = append(errs, f.Backends[name].validate("backends."+name)...)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (f *File) names() []string {
+	names := make([]string, 0, len(f.Backends))
+	for name := range f.Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b Backend) validate(path string) (errs ConfigErrors) {
+	if len(b.Sources) == 0 {
+		errs = append(errs, &ConfigError{Path: path + ".sources", Err: ErrNoSources})
+	}
+	for i, s := range b.Sources {
+		if _, err := parsePlugin(s, discoverlib.ConfigBase{Lenient: b.LenientURI}); err != nil {
+			errs = append(errs, &ConfigError{Path: path + ".sources[" + strconv.Itoa(i) + "]", Err: err})
+		}
+	}
+
+	switch strings.ToLower(b.Balancing) {
+	case "", BalancingRoundRobin, BalancingRandom:
+	default:
+		errs = append(errs, &ConfigError{Path: path + ".balancing", Err: ErrUnknownBalancing})
+	}
+
+	_, terrs := b.transport(path + ".transport")
+	errs = append(errs, terrs...)
+	_, terrs = b.tls(path + ".tls")
+	errs = append(errs, terrs...)
+
+	errs = append(errs, fieldErrors(path+".health_check", b.HealthCheck.Validate())...)
+	if b.OutlierDetection != nil {
+		errs = append(errs, fieldErrors(path+".outlier_detection", b.OutlierDetection.Validate())...)
+	}
+	if b.CircuitBreaker != nil {
+		errs = append(errs, fieldErrors(path+".circuit_breaker", b.CircuitBreaker.Validate())...)
+	}
+	if b.PanicThreshold < 0 || b.PanicThreshold > 1 {
+		errs = append(errs, &ConfigError{
+			Path: path + ".panic_threshold",
+			Err:  fmt.Errorf("%w: %v", discoverlib.ErrInvalidValue, b.PanicThreshold),
+		})
+	}
+	if b.MinReadyResources < 0 {
+		errs = append(errs, &ConfigError{
+			Path: path + ".min_ready_resources",
+			Err:  fmt.Errorf("%w: %v", discoverlib.ErrInvalidValue, b.MinReadyResources),
+		})
+	}
+	if b.SlowStart != nil {
+		errs = append(errs, fieldErrors(path+".slow_start", b.SlowStart.Validate())...)
+	}
+	return
+}
+
+// fieldErrors converts the resource.FieldErrors to ConfigErrors
+func fieldErrors(path string, err error) (errs ConfigErrors) {
+	var ferrs resource.FieldErrors
+	if errors.As(err, &ferrs) {
+		for _, err := range ferrs {
+			errs = append(errs, &ConfigError{Path: path + "." + err.Field, Err: err.Err})
+		}
+	}
+	return
+}
+
+// transport parses the transport options, timeout is used as
+// response_header_timeout if the latter is not defined
+func (b Backend) transport(path string) (o discoverlib.TransportOptions, errs ConfigErrors) {
+	o.ResponseHeaderTimeout = time.Duration(b.Timeout)
+	for _, k := range sortedKeys(b.Transport) {
+		known, err := o.Set(k, fmt.Sprint(b.Transport[k]))
+		if !known {
+			err = discoverlib.ErrUnknownKey
+		}
+		if err != nil {
+			errs = append(errs, &ConfigError{Path: path + "." + k, Err: err})
+		}
+	}
+	return
+}
+
+func (b Backend) tls(path string) (o discoverlib.TLSOptions, errs ConfigErrors) {
+	for _, k := range sortedKeys(b.TLS) {
+		known, err := o.Set("tls_"+k, fmt.Sprint(b.TLS[k]))
+		if !known {
+			err = discoverlib.ErrUnknownKey
+		}
+		if err != nil {
+			errs = append(errs, &ConfigError{Path: path + "." + k, Err: err})
+		}
+	}
+	return
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// config of the backend, the options that are not in the File, like the
+// Registerer, the TracerProvider or the Logger, are taken from base
+func (b Backend) config(name string, base Config) Config {
+	transport, _ := b.transport("")
+	tls, _ := b.tls("")
+	c := base
+	c.Label = name
+	c.DiscoverURI = b.Sources
+	c.HealtCheck = b.HealthCheck
+	c.Balancing = b.Balancing
+	c.OutlierDetection = b.OutlierDetection
+	c.CircuitBreaker = b.CircuitBreaker
+	c.SlowStart = b.SlowStart
+	c.PanicThreshold = b.PanicThreshold
+	c.LocalZone = b.LocalZone
+	c.MinReadyResources = b.MinReadyResources
+	c.Transport = transport
+	c.TLS = tls
+	c.LenientURI = b.LenientURI
+	return c
+}
+
+// Backends contains the Discover instances created from a File. The
+// configuration can be applied again, only the added or changed backends
+// are created and the removed or changed ones are closed
+type Backends struct {
+	mu       sync.Mutex
+	path     string
+	base     Config
+	backends map[string]Backend
+	discover map[string]*Discover
+}
+
+// LoadFile reads the configuration from the path and creates a Discover
+// for every backend like NewBackends. Reload will read the same path again
+func LoadFile(path string, base Config) (*Backends, error) {
+	f, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewBackends(f, base)
+	if err != nil {
+		return nil, err
+	}
+	b.path = path
+	return b, nil
+}
+
+// NewBackends creates a Discover for every backend of the File. The
+// options that can't be written in the File, like Registerer,
+// MetricsNamespace, TracerProvider or Logger, are taken from base
+func NewBackends(f *File, base Config) (*Backends, error) {
+	b := &Backends{
+		base:     base,
+		backends: make(map[string]Backend),
+		discover: make(map[string]*Discover),
+	}
+	if err := b.apply(f); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload reads again the file used in LoadFile and applies the changes.
+// If the new file is not valid the running backends are not modified
+func (b *Backends) Reload() error {
+	f, err := ReadFile(b.path)
+	if err != nil {
+		return err
+	}
+	return b.Apply(f)
+}
+
+// Apply creates, replaces or closes the Discover instances to match the
+// File. If the File is not valid or one of the new backends fails
+// nothing is modified
+func (b *Backends) Apply(f *File) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.apply(f)
+}
+
+func (b *Backends) apply(f *File) error {
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	created := make(map[string]*Discover)
+	for name, c := range f.Backends {
+		if old, ok := b.backends[name]; ok && reflect.DeepEqual(old, c) {
+			continue
+		}
+		d, err := New(c.config(name, b.base))
+		if err != nil {
+			for _, d := range created {
+				d.Exit()
+			}
+			return &ConfigError{Path: "backends." + name, Err: err}
+		}
+		created[name] = d
+	}
+
+	for name, d := range b.discover {
+		if _, ok := f.Backends[name]; !ok || created[name] != nil {
+			d.Exit()
+			delete(b.discover, name)
+			delete(b.backends, name)
+		}
+	}
+	for name, d := range created {
+		b.discover[name] = d
+		b.backends[name] = f.Backends[name]
+	}
+	return nil
+}
+
+// Get returns the Discover of the backend or nil if it doesn't exist
+func (b *Backends) Get(name string) *Discover {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.discover[name]
+}
+
+// Map returns a copy of the Discover instances by backend name
+func (b *Backends) Map() map[string]*Discover {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := make(map[string]*Discover, len(b.discover))
+	for name, d := range b.discover {
+		m[name] = d
+	}
+	return m
+}
+
+// Ready is true when all the backends are Ready
+func (b *Backends) Ready() bool {
+	for _, d := range b.Map() {
+		if !d.Ready() {
+			return false
+		}
+	}
+	return true
+}
+
+// Health returns the Health errors of the backends by name
+func (b *Backends) Health() map[string]error {
+	errs := make(map[string]error)
+	for name, d := range b.Map() {
+		if err := d.Health(); err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+// WaitReady waits until all the backends are ready with WaitReady, the
+// HealthError has the errors by backend name
+func (b *Backends) WaitReady(ctx context.Context) error {
+	errs := make(map[string]error)
+	for name, d := range b.Map() {
+		if err := d.WaitReady(ctx); err != nil {
+			errs[name] = err
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &HealthError{Errs: errs}
+}
+
+// Exit closes all the Discover instances
+func (b *Backends) Exit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, d := range b.discover {
+		d.Exit()
+		delete(b.discover, name)
+		delete(b.backends, name)
+	}
+}
@@ -0,0 +1,283 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+	"github.com/gabrielperezs/discover/resource"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseFile(t *testing.T) {
+	yamlFile := []byte(`
+backends:
+  api:
+    sources:
+      - dns://127.0.0.1:80?refresh=10s
+    balancing: random
+    timeout: 30s
+    transport:
+      dial_timeout: 2s
+      max_conns_per_host: 50
+      http2: true
+    health_check:
+      url: http://api/health
+      resp_code: 200
+      interval: 5s
+`)
+	jsonFile := []byte(`{"backends": {"api": {
+		"sources": ["dns://127.0.0.1:80?refresh=10s"],
+		"balancing": "random",
+		"timeout": "30s",
+		"transport": {"dial_timeout": "2s", "max_conns_per_host": 50, "http2": true},
+		"health_check": {"url": "http://api/health", "resp_code": 200, "interval": "5s"}
+	}}}`)
+
+	for _, b := range [][]byte{yamlFile, jsonFile} {
+		f, err := ParseFile(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := f.Backends["api"].config("api", Config{})
+		if c.Label != "api" || c.Balancing != BalancingRandom {
+			t.Errorf("Invalid config %+v", c)
+		}
+		o := c.Transport
+		if o.ResponseHeaderTimeout != 30*time.Second || o.DialTimeout != 2*time.Second ||
+			o.MaxConnsPerHost != 50 || o.HTTP2 == nil || !*o.HTTP2 {
+			t.Errorf("Invalid transport %+v", o)
+		}
+		if c.HealtCheck.RespCode != 200 || c.HealtCheck.Interval != "5s" {
+			t.Errorf("Invalid health check %+v", c.HealtCheck)
+		}
+	}
+}
+
+func TestParseFileErrors(t *testing.T) {
+	_, err := ParseFile([]byte(`
+backends:
+  api:
+    sources:
+      - dns://127.0.0.1:80
+      - ftp://127.0.0.1:80
+    balancing: fastest
+    transport:
+      max_conns_per_host: -1
+      pool: 10
+  web:
+    health_check:
+      interval: often
+`))
+	var errs ConfigErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Expected ConfigErrors, got %v", err)
+	}
+
+	paths := []string{
+		"backends.api.sources[1]",
+		"backends.api.balancing",
+		"backends.api.transport.max_conns_per_host",
+		"backends.api.transport.pool",
+		"backends.web.sources",
+		"backends.web.health_check.interval",
+	}
+	if len(errs) != len(paths) {
+		t.Fatalf("Expected %d errors, got %v", len(paths), errs)
+	}
+	for i, p := range paths {
+		if errs[i].Path != p {
+			t.Errorf("error %v != %v", errs[i].Path, p)
+		}
+	}
+	if !errors.Is(errs[0], ErrErrorPlugin) {
+		t.Errorf("Invalid error %v", errs[0])
+	}
+	// The transport errors come from discoverlib with the same sentinel
+	if !errors.Is(errs[2], discoverlib.ErrInvalidValue) {
+		t.Errorf("Invalid error %v", errs[2])
+	}
+
+	if _, err := ParseFile([]byte(`{"backends": {"api": {"sources": ["dns://127.0.0.1:80"], "timeout": 30}}}`)); err == nil {
+		t.Error("Expected error for numeric timeout")
+	}
+}
+
+func TestBackendsApply(t *testing.T) {
+	f, err := ParseFile([]byte(`
+backends:
+  api:
+    sources: ["dns://127.0.0.1:80?refresh=10s"]
+  web:
+    sources: ["dns://127.0.0.1:8080?refresh=10s"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBackends(f, Config{Registerer: prometheus.NewRegistry()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Exit()
+
+	api, web := b.Get("api"), b.Get("web")
+	if api == nil || web == nil {
+		t.Fatal("Backends not created")
+	}
+
+	f, err = ParseFile([]byte(`
+backends:
+  api:
+    sources: ["dns://127.0.0.1:80?refresh=10s"]
+  web:
+    sources: ["dns://127.0.0.1:8080?refresh=10s"]
+    balancing: random
+  admin:
+    sources: ["dns://127.0.0.1:9090?refresh=10s"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Apply(f); err != nil {
+		t.Fatal(err)
+	}
+
+	m := b.Map()
+	if len(m) != 3 {
+		t.Fatalf("Invalid number of backends %d", len(m))
+	}
+	if m["api"] != api {
+		t.Error("Unchanged backend was recreated")
+	}
+	if m["web"] == web {
+		t.Error("Changed backend was not recreated")
+	}
+
+	delete(f.Backends, "admin")
+	if err := b.Apply(f); err != nil {
+		t.Fatal(err)
+	}
+	if b.Get("admin") != nil {
+		t.Error("Removed backend still exists")
+	}
+}
+
+func TestBackendsBase(t *testing.T) {
+	f, err := ParseFile([]byte(`
+backends:
+  api:
+    sources: ["dns://127.0.0.1:80?refresh=10s"]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	b, err := NewBackends(f, Config{Label: "ignored", Registerer: reg, MetricsNamespace: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Exit()
+
+	if d := b.Get("api"); d == nil || d.Label != "api" {
+		t.Fatal("Backend without its name as Label")
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, f := range families {
+		found = found || f.GetName() == "test_discover_panic"
+	}
+	if !found {
+		t.Error("Metrics not registered in the Registerer of the base")
+	}
+}
+
+func TestBackendsApplyInvalid(t *testing.T) {
+	f := &File{Backends: map[string]Backend{
+		"api": {
+			Sources:     []string{"dns://127.0.0.1:80?refresh=10s"},
+			HealthCheck: resource.HealthCheck{Type: "bogus"},
+		},
+	}}
+	if _, err := NewBackends(f, Config{}); err == nil || !strings.Contains(err.Error(), "backends.api.health_check.type") {
+		t.Errorf("Expected error of backends.api.health_check.type, got %v", err)
+	}
+}
+
+func TestBackendsApplyKeepsMetrics(t *testing.T) {
+	delay := exitingDelay
+	exitingDelay = 0
+	defer func() { exitingDelay = delay }()
+
+	f, err := ParseFile([]byte(`
+backends:
+  reload:
+    sources: ["dns://127.0.0.1:80?refresh=10s"]
+    circuit_breaker: {}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg := prometheus.NewRegistry()
+	b, err := NewBackends(f, Config{Registerer: reg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Exit()
+	old := b.Get("reload")
+	if err := old.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	c := f.Backends["reload"]
+	c.Balancing = BalancingRandom
+	f.Backends["reload"] = c
+	if err := b.Apply(f); err != nil {
+		t.Fatal(err)
+	}
+	d := b.Get("reload")
+	if d == old {
+		t.Fatal("Changed backend was not recreated")
+	}
+	if err := d.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-old.exited:
+	case <-time.After(time.Second):
+		t.Fatal("Old backend not exited")
+	}
+
+	for _, name := range []string{"wbrouter_discover_resources", "wbrouter_discover_panic", "wbrouter_discover_plugin_updates", "wbrouter_backend_circuit_state"} {
+		if !hasSeries(t, reg, name, "reload") {
+			t.Errorf("Series %s deleted by the old backend", name)
+		}
+	}
+}
+
+// hasSeries is true when the registry has a series of the metric with
+// the label
+func hasSeries(t *testing.T, reg *prometheus.Registry, name, label string) bool {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "Label" && l.GetValue() == label {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
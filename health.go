@@ -0,0 +1,145 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrNotReady when no plugin has sent resources yet
+	ErrNotReady = errors.New("Not ready")
+	// ErrPluginFailing when the last update of a plugin failed
+	ErrPluginFailing = errors.New("Plugin failing")
+	// ErrPluginPending when a plugin has not sent its first update
+	ErrPluginPending = errors.New("Plugin pending")
+)
+
+// waitReadyInterval between the checks of WaitReady
+const waitReadyInterval = 50 * time.Millisecond
+
+// HealthError describes why the Discover is not ready or degraded. It
+// wraps ErrNotReady, ErrNoResources or ErrPluginFailing
+type HealthError struct {
+	// Errs by plugin source URI, or the Label for the Discover
+	Errs map[string]error
+}
+
+func (e *HealthError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for k, err := range e.Errs {
+		msgs = append(msgs, k+": "+err.Error())
+	}
+	sort.Strings(msgs)
+	return strings.Join(msgs, "; ")
+}
+
+// Is matches any of the errors
+func (e *HealthError) Is(target error) bool {
+	for _, err := range e.Errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ready is true when a plugin has updated successfully and there is at
+// least one available resource, even if other plugins are failing
+func (d *Discover) Ready() bool {
+	return d.ready() == nil
+}
+
+func (d *Discover) ready() error {
+	updated := false
+	for _, s := range d.sources() {
+		if !s.plugin.Status().LastSuccess.IsZero() {
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		return ErrNotReady
+	}
+	for _, r := range d.Resources() {
+		if d.available(r) {
+			return nil
+		}
+	}
+	return ErrNoResources
+}
+
+// Health returns nil when the Discover is Ready and all the plugins work,
+// otherwise a HealthError
+func (d *Discover) Health() error {
+	errs := make(map[string]error)
+	if err := d.ready(); err != nil {
+		errs[d.Label] = err
+	}
+	for _, src := range d.sources() {
+		if s := src.plugin.Status(); s.Failing() {
+			errs[src.uri] = fmt.Errorf("%w: %d consecutive failures: %v", ErrPluginFailing, s.ConsecutiveFailures, s.LastError)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &HealthError{Errs: errs}
+}
+
+// WaitReady blocks until every plugin has sent its first update and
+// MinReadyResources resources are healthy. When the context is done it
+// returns a HealthError with the pending plugins, which also matches the
+// error of the context
+func (d *Discover) WaitReady(ctx context.Context) error {
+	t := time.NewTicker(waitReadyInterval)
+	defer t.Stop()
+	for {
+		pending, healthy := d.pending()
+		if len(pending) == 0 && healthy >= d.minReadyResources() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			errs := make(map[string]error, len(pending)+1)
+			for _, src := range pending {
+				err := fmt.Errorf("%w: no update yet", ErrPluginPending)
+				if s := src.plugin.Status(); s.LastError != nil {
+					err = fmt.Errorf("%w: %v", ErrPluginPending, s.LastError)
+				}
+				errs[src.uri] = err
+			}
+			errs[d.Label] = fmt.Errorf("%w: %d of %d resources healthy", ctx.Err(), healthy, d.minReadyResources())
+			return &HealthError{Errs: errs}
+		case <-t.C:
+		}
+	}
+}
+
+// pending returns the sources without updates and the number of healthy
+// resources
+func (d *Discover) pending() (pending []*source, healthy int) {
+	for _, s := range d.sources() {
+		if atomic.LoadInt64(&s.updated) == 0 {
+			pending = append(pending, s)
+		}
+	}
+	for _, r := range d.Resources() {
+		if r.IsHealthy() {
+			healthy++
+		}
+	}
+	return pending, healthy
+}
+
+func (d *Discover) minReadyResources() int {
+	if d.minReady > 0 {
+		return d.minReady
+	}
+	return 1
+}
@@ -0,0 +1,108 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+type testPlugin struct {
+	discoverlib.StatusRecorder
+}
+
+func (p *testPlugin) Get() chan []string                      { return nil }
+func (p *testPlugin) Protocol() string                        { return "" }
+func (p *testPlugin) Weight() int64                           { return 0 }
+func (p *testPlugin) Priority() int                           { return 0 }
+func (p *testPlugin) Zone(string) string                      { return "" }
+func (p *testPlugin) Transport() discoverlib.TransportOptions { return discoverlib.TransportOptions{} }
+func (p *testPlugin) TLS() discoverlib.TLSOptions             { return discoverlib.TLSOptions{} }
+func (p *testPlugin) Hostname() string                        { return "" }
+func (p *testPlugin) Exit()                                   {}
+
+func TestHealth(t *testing.T) {
+	a, b := &testPlugin{}, &testPlugin{}
+	d := &Discover{Label: "test"}
+	d.store(make(Resources, 0))
+	d.add("test://a", a)
+	d.add("test://b", b)
+
+	if d.Ready() || !errors.Is(d.Health(), ErrNotReady) {
+		t.Errorf("Ready before any update: %v", d.Health())
+	}
+
+	a.SetError(nil)
+	if d.Ready() || !errors.Is(d.Health(), ErrNoResources) {
+		t.Errorf("Ready without resources: %v", d.Health())
+	}
+
+	d.update([]string{"10.0.0.1:80"}, d.sources()[0])
+	defer d.Resources()[0].Close()
+	if !d.Ready() || d.Health() != nil {
+		t.Errorf("Not ready: %v", d.Health())
+	}
+
+	// A failing plugin degrades the health but it is still ready
+	b.SetError(errors.New("lookup failed"))
+	b.SetError(errors.New("lookup failed"))
+	err := d.Health()
+	if !d.Ready() || !errors.Is(err, ErrPluginFailing) {
+		t.Errorf("Invalid health: %v", err)
+	}
+	if s := b.Status(); s.ConsecutiveFailures != 2 || s.LastError == nil || !s.LastSuccess.IsZero() {
+		t.Errorf("Invalid status %+v", s)
+	}
+
+	b.SetError(nil)
+	if s := b.Status(); s.Failing() || s.LastError == nil {
+		t.Errorf("Invalid status after success %+v", s)
+	}
+	if err := d.Health(); err != nil {
+		t.Errorf("Not healthy after the success: %v", err)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	a, b := &testPlugin{}, &testPlugin{}
+	d := &Discover{Label: "test"}
+	d.store(make(Resources, 0))
+	d.add("test://a", a)
+	d.add("test://b", b)
+
+	d.update([]string{"10.0.0.1:80"}, d.sources()[0])
+	defer d.Resources()[0].Close()
+	atomic.StoreInt64(&d.sources()[0].updated, time.Now().UnixNano())
+	b.SetError(errors.New("lookup failed"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := d.WaitReady(ctx)
+	if !errors.Is(err, ErrPluginPending) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Invalid error: %v", err)
+	}
+	if !strings.Contains(err.Error(), "test://b: Plugin pending: lookup failed") || strings.Contains(err.Error(), "test://a") {
+		t.Errorf("Invalid pending plugins: %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt64(&d.sources()[1].updated, time.Now().UnixNano())
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := d.WaitReady(ctx); err != nil {
+		t.Errorf("Not ready: %v", err)
+	}
+
+	d.minReady = 2
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := d.WaitReady(ctx); err == nil || !strings.Contains(err.Error(), "1 of 2 resources healthy") {
+		t.Errorf("Invalid error: %v", err)
+	}
+}
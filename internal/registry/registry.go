@@ -0,0 +1,111 @@
+// Package registry registers the metric vectors shared by the Discover
+// and the resources and tracks the owners of their series
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// ErrMetricType when a metric is already registered with other type
+	ErrMetricType = errors.New("Metric registered with other type")
+
+	// owners of the series, see Acquire
+	owners   = make(map[interface{}]int)
+	ownersMu sync.Mutex
+)
+
+// Register registers c in reg, or returns the collector already
+// registered with the same description
+func Register(reg prometheus.Registerer, c prometheus.Collector) (prometheus.Collector, error) {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			return are.ExistingCollector, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// Registration registers metric vectors with Register and keeps the
+// first error, so the vectors of a struct can be registered in a single
+// expression and the error checked at the end
+type Registration struct {
+	Registerer prometheus.Registerer
+	Err        error
+}
+
+func (r *Registration) register(c prometheus.Collector) prometheus.Collector {
+	if r.Err != nil {
+		return nil
+	}
+	existing, err := Register(r.Registerer, c)
+	if err != nil {
+		r.Err = err
+	}
+	return existing
+}
+
+// CounterVec registers c, or returns the one already registered
+func (r *Registration) CounterVec(c *prometheus.CounterVec) *prometheus.CounterVec {
+	v, ok := r.register(c).(*prometheus.CounterVec)
+	if !ok {
+		r.typeError(c)
+	}
+	return v
+}
+
+// GaugeVec registers c, or returns the one already registered
+func (r *Registration) GaugeVec(c *prometheus.GaugeVec) *prometheus.GaugeVec {
+	v, ok := r.register(c).(*prometheus.GaugeVec)
+	if !ok {
+		r.typeError(c)
+	}
+	return v
+}
+
+// HistogramVec registers c, or returns the one already registered
+func (r *Registration) HistogramVec(c *prometheus.HistogramVec) *prometheus.HistogramVec {
+	v, ok := r.register(c).(*prometheus.HistogramVec)
+	if !ok {
+		r.typeError(c)
+	}
+	return v
+}
+
+func (r *Registration) typeError(c prometheus.Collector) {
+	if r.Err != nil {
+		return
+	}
+	// The vectors have a single description
+	desc := make(chan *prometheus.Desc, 1)
+	c.Describe(desc)
+	r.Err = fmt.Errorf("%w: %v", ErrMetricType, <-desc)
+}
+
+// Acquire counts a new user of the series identified by key. Many
+// instances use the same series, like a backend and its replacement
+// after a reload, so only the last one must delete them
+func Acquire(key interface{}) {
+	ownersMu.Lock()
+	defer ownersMu.Unlock()
+	owners[key]++
+}
+
+// Release counts one user less of the series, true when it was the
+// last one and the series can be deleted
+func Release(key interface{}) bool {
+	ownersMu.Lock()
+	defer ownersMu.Unlock()
+	owners[key]--
+	if owners[key] > 0 {
+		return false
+	}
+	delete(owners, key)
+	return true
+}
@@ -0,0 +1,81 @@
+package discover
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+// logEntry is a message of the testLogger with its fields
+type logEntry struct {
+	level  string
+	msg    string
+	fields map[string]string
+}
+
+// testLogger records the messages, it implements discoverlib.Logger
+type testLogger struct {
+	sync.Mutex
+	entries []logEntry
+}
+
+func (l *testLogger) log(level, msg string, args []interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields[fmt.Sprint(args[i])] = fmt.Sprint(args[i+1])
+	}
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, fields: fields})
+}
+
+func (l *testLogger) Debug(msg string, args ...interface{}) { l.log("debug", msg, args) }
+func (l *testLogger) Info(msg string, args ...interface{})  { l.log("info", msg, args) }
+func (l *testLogger) Warn(msg string, args ...interface{})  { l.log("warn", msg, args) }
+func (l *testLogger) Error(msg string, args ...interface{}) { l.log("error", msg, args) }
+
+func (l *testLogger) all() []logEntry {
+	l.Lock()
+	defer l.Unlock()
+	return append([]logEntry(nil), l.entries...)
+}
+
+func TestLogger(t *testing.T) {
+	l := &testLogger{}
+	d := newTestDiscover(t, &Discover{
+		Label:  "test",
+		logger: discoverlib.With(l, "label", "test"),
+	})
+	d.update([]string{"10.0.0.1:80"}, d.sources()[0])
+
+	entries := l.all()
+	if len(entries) == 0 || entries[0].msg != "resource added" || entries[0].level != "info" {
+		t.Fatalf("Invalid entries %v", entries)
+	}
+	expected := map[string]string{
+		"label":  "test",
+		"plugin": "dns://127.0.0.1:80",
+		"host":   "10.0.0.1:80",
+	}
+	for k, v := range expected {
+		if entries[0].fields[k] != v {
+			t.Errorf("Invalid %s: %v", k, entries[0].fields[k])
+		}
+	}
+}
+
+func TestLoggerLenient(t *testing.T) {
+	l := &testLogger{}
+	d := &Discover{logger: l}
+	if err := d.loadPlugins([]string{"dns://127.0.0.1:80?refresh=10s&unknown=1"}, true); err != nil {
+		t.Fatal(err)
+	}
+	defer d.exitPlugins(d.stopSources())
+	entries := l.all()
+	if len(entries) == 0 || entries[0].level != "warn" ||
+		entries[0].fields["plugin"] != "dns://127.0.0.1:80" || entries[0].fields["key"] != "unknown" {
+		t.Errorf("Invalid warning %v", entries)
+	}
+}
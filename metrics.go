@@ -0,0 +1,177 @@
+package discover
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/gabrielperezs/discover/internal/registry"
+	"github.com/gabrielperezs/discover/resource"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultMetrics     *metrics
+	defaultMetricsOnce sync.Once
+)
+
+// metrics of the Discover labelled by its Label
+type metrics struct {
+	noResources        *prometheus.CounterVec
+	resources          *prometheus.GaugeVec
+	resourcesUnhealthy *prometheus.GaugeVec
+	zoneRouting        *prometheus.CounterVec
+	panic              *prometheus.GaugeVec
+	resourcesAdded     *prometheus.CounterVec
+	resourcesRemoved   *prometheus.CounterVec
+	pluginUpdates      *prometheus.CounterVec
+	pluginLastSuccess  *prometheus.GaugeVec
+}
+
+// newMetrics registers the metrics like resource.NewMetrics, with the
+// same errors
+func newMetrics(reg prometheus.Registerer, namespace string) (*metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if namespace == "" {
+		namespace = resource.DefaultNamespace
+	}
+	r := &registry.Registration{Registerer: reg}
+	m := &metrics{
+		noResources: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "discover_no_resources",
+		}, []string{"Label"})),
+		resources: r.GaugeVec(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "discover_resources",
+		}, []string{"Label"})),
+		resourcesUnhealthy: r.GaugeVec(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "discover_resources_unhealthy",
+		}, []string{"Label"})),
+		zoneRouting: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "discover_zone_routing",
+			Help:      "Resources chosen in the local zone or in other zones",
+		}, []string{"Label", "zone"})),
+		panic: r.GaugeVec(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "discover_panic",
+			Help:      "1 when the healthy resources are below the panic threshold",
+		}, []string{"Label"})),
+		resourcesAdded: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "discover_resources_added",
+			Help:      "Resources created from the updates of the plugins",
+		}, []string{"Label"})),
+		resourcesRemoved: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "discover_resources_removed",
+			Help:      "Resources closed because the plugins stopped sending them",
+		}, []string{"Label"})),
+		pluginUpdates: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "discover_plugin_updates",
+			Help:      "Updates of the plugins by result: success or error",
+		}, []string{"Label", "Plugin", "Result"})),
+		pluginLastSuccess: r.GaugeVec(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "discover_plugin_last_success_timestamp_seconds",
+			Help:      "Time of the last successful update of the plugin",
+		}, []string{"Label", "Plugin"})),
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	return m, nil
+}
+
+// get returns the default metrics for the Discover created without New
+func (m *metrics) get() *metrics {
+	if m != nil {
+		return m
+	}
+	defaultMetricsOnce.Do(func() {
+		var err error
+		if defaultMetrics, err = newMetrics(nil, ""); err != nil {
+			defaultMetrics, _ = newMetrics(prometheus.NewRegistry(), "")
+		}
+	})
+	return defaultMetrics
+}
+
+// pluginLabel is the source URI of the plugin without the query
+func pluginLabel(source string) string {
+	u, err := url.Parse(source)
+	if err != nil {
+		return source
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// observePlugin returns the Observer of the plugin of the source URI
+func (d *Discover) observePlugin(source string) func(error) {
+	plugin := pluginLabel(source)
+	return func(err error) {
+		m := d.metrics.get()
+		if err != nil {
+			m.pluginUpdates.WithLabelValues(d.Label, plugin, "error").Inc()
+			return
+		}
+		m.pluginUpdates.WithLabelValues(d.Label, plugin, "success").Inc()
+		m.pluginLastSuccess.WithLabelValues(d.Label, plugin).SetToCurrentTime()
+	}
+}
+
+// labelKey identifies the series of a Discover in the vectors of a
+// registry, a reload shares them between the old and the new instance
+type labelKey struct {
+	id    *prometheus.CounterVec
+	label string
+}
+
+// pluginKey identifies the series of a plugin of a Discover, many sources
+// can have the same plugin label
+type pluginKey struct {
+	id            *prometheus.CounterVec
+	label, plugin string
+}
+
+// acquire counts the Discover of the label as an owner of its series
+func (m *metrics) acquire(label string) {
+	registry.Acquire(labelKey{id: m.noResources, label: label})
+}
+
+// release deletes the series of the label if the Discover was the last
+// owner
+func (m *metrics) release(label string) {
+	if !registry.Release(labelKey{id: m.noResources, label: label}) {
+		return
+	}
+	m.noResources.DeleteLabelValues(label)
+	m.resources.DeleteLabelValues(label)
+	m.resourcesUnhealthy.DeleteLabelValues(label)
+	m.zoneRouting.DeleteLabelValues(label, "local")
+	m.zoneRouting.DeleteLabelValues(label, "remote")
+	m.panic.DeleteLabelValues(label)
+	m.resourcesAdded.DeleteLabelValues(label)
+	m.resourcesRemoved.DeleteLabelValues(label)
+}
+
+// acquirePlugin counts the source as an owner of the series of its plugin
+func (m *metrics) acquirePlugin(label, source string) {
+	registry.Acquire(pluginKey{id: m.noResources, label: label, plugin: pluginLabel(source)})
+}
+
+// releasePlugin deletes the series of the plugin of the source if it was
+// the last owner
+func (m *metrics) releasePlugin(label, source string) {
+	plugin := pluginLabel(source)
+	if !registry.Release(pluginKey{id: m.noResources, label: label, plugin: plugin}) {
+		return
+	}
+	m.pluginUpdates.DeleteLabelValues(label, plugin, "success")
+	m.pluginUpdates.DeleteLabelValues(label, plugin, "error")
+	m.pluginLastSuccess.DeleteLabelValues(label, plugin)
+}
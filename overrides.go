@@ -0,0 +1,119 @@
+package discover
+
+import (
+	"time"
+
+	"github.com/gabrielperezs/discover/resource"
+)
+
+// Manual overrides of the resources
+const (
+	// OverrideDrain stops sending new requests to the resource, the ones
+	// in flight finish normally
+	OverrideDrain = "drain"
+	// OverrideDisable is like OverrideDrain but also closes the idle
+	// connections of the resource
+	OverrideDisable = "disable"
+	// OverridePin keeps the resource in rotation even if it is unhealthy,
+	// ejected or its circuit is open
+	OverridePin = "pin"
+)
+
+type override struct {
+	mode  string
+	until time.Time
+}
+
+func (o override) expired(now time.Time) bool {
+	return !o.until.IsZero() && now.After(o.until)
+}
+
+// Drain stops sending new requests to the host during ttl, 0 is forever
+func (d *Discover) Drain(host string, ttl time.Duration) {
+	d.setOverride(host, OverrideDrain, ttl)
+}
+
+// Disable takes the host out of rotation during ttl, 0 is forever, and
+// closes its idle connections
+func (d *Discover) Disable(host string, ttl time.Duration) {
+	d.setOverride(host, OverrideDisable, ttl)
+	for _, r := range d.Resources() {
+		if r.Host == host {
+			r.Transport.CloseIdleConnections()
+		}
+	}
+}
+
+// Pin keeps the host in rotation during ttl, 0 is forever, ignoring its
+// health
+func (d *Discover) Pin(host string, ttl time.Duration) {
+	d.setOverride(host, OverridePin, ttl)
+}
+
+// Enable removes the override of the host
+func (d *Discover) Enable(host string) {
+	d.setOverride(host, "", 0)
+}
+
+// setOverride replaces the map of overrides, so it is read without locks
+// by NextHealthy. They are kept by host when the plugins refresh the
+// resources. The expired ones are dropped
+func (d *Discover) setOverride(host, mode string, ttl time.Duration) {
+	d.overridesMu.Lock()
+	defer d.overridesMu.Unlock()
+
+	now := time.Now()
+	m := make(map[string]override)
+	for h, o := range d.overrides() {
+		if !o.expired(now) {
+			m[h] = o
+		}
+	}
+	delete(m, host)
+	if mode != "" {
+		o := override{mode: mode}
+		if ttl > 0 {
+			o.until = now.Add(ttl)
+		}
+		m[host] = o
+	}
+	d.atomicOverrides.Store(m)
+}
+
+func (d *Discover) overrides() map[string]override {
+	m, _ := d.atomicOverrides.Load().(map[string]override)
+	return m
+}
+
+// Override returns the mode of the active override of the host, empty if
+// there isn't any
+func (d *Discover) Override(host string) (mode string, until time.Time) {
+	if o, ok := d.overrides()[host]; ok && !o.expired(time.Now()) {
+		return o.mode, o.until
+	}
+	return "", time.Time{}
+}
+
+// available applies the overrides to Available
+func (d *Discover) available(r *resource.Resource) bool {
+	if len(d.overrides()) > 0 {
+		switch mode, _ := d.Override(r.Host); mode {
+		case OverrideDrain, OverrideDisable:
+			return false
+		case OverridePin:
+			return true
+		}
+	}
+	return r.Available()
+}
+
+// usable are the resources not drained or disabled, used in panic mode
+func (d *Discover) usable(r *resource.Resource) bool {
+	if len(d.overrides()) > 0 {
+		switch mode, _ := d.Override(r.Host); mode {
+		case OverrideDrain, OverrideDisable:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package discover
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/resource"
+)
+
+func TestOverrides(t *testing.T) {
+	d := newTestDiscover(t, &Discover{})
+	hosts := []string{"10.0.0.1:80", "10.0.0.2:80"}
+	d.update(hosts, d.sources()[0])
+
+	used := func() map[string]int {
+		m := make(map[string]int)
+		for i := 0; i < 10; i++ {
+			if r := d.NextHealthy(); r != nil {
+				m[r.Host]++
+			}
+		}
+		return m
+	}
+
+	d.Drain(hosts[0], 0)
+	if m := used(); m[hosts[0]] > 0 || m[hosts[1]] != 10 {
+		t.Errorf("Drained host used %v", m)
+	}
+
+	// The override survives the refresh of the plugin
+	d.update(hosts, d.sources()[0])
+	if m := used(); m[hosts[0]] > 0 {
+		t.Errorf("Override lost after the refresh %v", m)
+	}
+
+	d.Disable(hosts[1], 0)
+	if r := d.NextHealthy(); r != nil {
+		t.Errorf("Disabled host used %v", r.Host)
+	}
+
+	d.Enable(hosts[0])
+	if m := used(); m[hosts[0]] != 10 {
+		t.Errorf("Enabled host not used %v", m)
+	}
+
+	d.Drain(hosts[0], 20*time.Millisecond)
+	if mode, _ := d.Override(hosts[0]); mode != OverrideDrain {
+		t.Errorf("Invalid override %q", mode)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if mode, _ := d.Override(hosts[0]); mode != "" {
+		t.Errorf("Override not expired %q", mode)
+	}
+	if m := used(); m[hosts[0]] != 10 {
+		t.Errorf("Host not used after the TTL %v", m)
+	}
+}
+
+func TestPin(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	d := newTestDiscover(t, &Discover{
+		healthCheck: resource.HealthCheck{Type: resource.HealthCheckTCP, Interval: "20ms", Timeout: "100ms"},
+	})
+	d.update([]string{ln.Addr().String()}, d.sources()[0])
+	r := d.Resources()[0]
+	eventually(t, func() bool { return !r.LastCheck().Time.IsZero() })
+	if d.NextHealthy() != nil {
+		t.Fatal("Unhealthy resource used")
+	}
+
+	d.Pin(r.Host, 0)
+	if d.NextHealthy() != r {
+		t.Error("Pinned resource not used")
+	}
+}
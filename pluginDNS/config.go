@@ -1,11 +1,8 @@
 package pluginDNS
 
 import (
-	"log"
 	"net"
 	"net/url"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gabrielperezs/discover/discoverlib"
@@ -21,26 +18,25 @@ type Config struct {
 
 func (c *Config) Load(u *url.URL) (err error) {
 	for k, v := range u.Query() {
-		switch strings.ToLower(k) {
-		case "refresh":
-			c.Refresh, _ = time.ParseDuration(v[0])
-		case "weight":
-			c.Weight, _ = strconv.ParseInt(v[0], 10, 64)
-		default:
-			log.Printf("WARN: unknown value in dns plugin %s %s", k, v)
+		known, err := c.Set(k, v[0])
+		if !known {
+			err = discoverlib.ErrUnknownKey
+		}
+		if err = c.Check(u, k, v[0], err); err != nil {
+			return err
 		}
 	}
+
 	c.Hostname, c.Port, err = net.SplitHostPort(u.Host)
+	if err != nil {
+		return &discoverlib.URIError{URI: u.String(), Key: "host", Value: u.Host, Err: err}
+	}
 
 	if c.Refresh.Nanoseconds() == 0 {
 		c.Refresh = defaultRefresh
 	}
 
-	for i, s := range strings.Split(u.Scheme, "+") {
-		if i == 1 {
-			c.Protocol = s
-		}
-	}
+	c.LoadProtocol(u)
 
 	return
 }
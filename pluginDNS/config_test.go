@@ -0,0 +1,87 @@
+package pluginDNS
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+func TestLoad(t *testing.T) {
+	u, _ := url.Parse("dns+https://www.example.com:443?refresh=10s&weight=2&priority=1&zone=eu-west-1a&timeout=3s&max_conns_per_host=10&http2=false")
+	c := Config{}
+	if err := c.Load(u); err != nil {
+		t.Fatal(err)
+	}
+	if c.Hostname != "www.example.com" || c.Port != "443" || c.Protocol != "https" {
+		t.Errorf("Invalid address %+v", c)
+	}
+	if c.Refresh != 10*time.Second || c.Weight != 2 || c.Priority != 1 || c.Zone != "eu-west-1a" {
+		t.Errorf("Invalid values %+v", c)
+	}
+	o := c.Transport
+	if o.ResponseHeaderTimeout != 3*time.Second || o.MaxConnsPerHost != 10 || o.HTTP2 == nil || *o.HTTP2 {
+		t.Errorf("Invalid transport %+v", o)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		uri   string
+		key   string
+		value string
+		err   error
+	}{
+		{"dns://www.example.com:80?refresh=often", "refresh", "often", nil},
+		{"dns://www.example.com:80?weight=-1", "weight", "-1", discoverlib.ErrInvalidValue},
+		{"dns://www.example.com:80?priority=-1", "priority", "-1", discoverlib.ErrInvalidValue},
+		{"dns://www.example.com:80?namespace=api", "namespace", "api", discoverlib.ErrUnknownKey},
+		{"dns://www.example.com", "host", "www.example.com", nil},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.uri)
+		c := Config{}
+		var e *discoverlib.URIError
+		if err := c.Load(u); !errors.As(err, &e) {
+			t.Errorf("%s: expected URIError, got %v", tt.uri, err)
+			continue
+		}
+		if e.URI != tt.uri || e.Key != tt.key || e.Value != tt.value {
+			t.Errorf("%s: invalid error %+v", tt.uri, e)
+		}
+		if tt.err != nil && !errors.Is(e, tt.err) {
+			t.Errorf("%s: error %v is not %v", tt.uri, e, tt.err)
+		}
+	}
+}
+
+func TestLoadLenient(t *testing.T) {
+	u, _ := url.Parse("dns://www.example.com:80?refresh=often&namespace=api")
+	c := Config{}
+	c.Lenient = true
+	if err := c.Load(u); err != nil {
+		t.Fatal(err)
+	}
+	if c.Refresh != defaultRefresh {
+		t.Errorf("Invalid refresh %v", c.Refresh)
+	}
+}
+
+func TestLoadLenientKeepsValues(t *testing.T) {
+	u, _ := url.Parse("dns://www.example.com:80?priority=-1&weight=-5&dial_timeout=-1s&max_conns_per_host=-2&http2=maybe")
+	c := Config{}
+	c.Lenient = true
+	c.Weight = 10
+	c.Transport.MaxConnsPerHost = 4
+	if err := c.Load(u); err != nil {
+		t.Fatal(err)
+	}
+	if c.Priority != 0 || c.Weight != 10 {
+		t.Errorf("Invalid values assigned, priority %d weight %d", c.Priority, c.Weight)
+	}
+	if c.Transport.DialTimeout != 0 || c.Transport.MaxConnsPerHost != 4 || c.Transport.HTTP2 != nil {
+		t.Errorf("Invalid transport values assigned %+v", c.Transport)
+	}
+}
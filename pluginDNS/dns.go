@@ -1,18 +1,25 @@
 package pluginDNS
 
 import (
+	"context"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/gabrielperezs/discover/discoverlib"
 	"github.com/tevino/abool"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type PluginDNS struct {
+	discoverlib.StatusRecorder
 	cfg     Config
 	C       chan []string
 	t       *time.Timer
 	refresh time.Duration
 	exit    *abool.AtomicBool
+	done    chan struct{}
+	wg      sync.WaitGroup
 }
 
 func New(c Config) *PluginDNS {
@@ -22,8 +29,11 @@ func New(c Config) *PluginDNS {
 		t:       time.NewTimer(c.Refresh),
 		refresh: c.Refresh,
 		exit:    abool.New(),
+		done:    make(chan struct{}),
 	}
+	l.SetObserver(c.Observer)
 	l.update()
+	l.wg.Add(1)
 	go l.interval()
 	return l
 }
@@ -40,35 +50,73 @@ func (l *PluginDNS) Weight() int64 {
 	return l.cfg.Weight
 }
 
-func (l *PluginDNS) Timeout() time.Duration {
-	return l.cfg.Timeout
+func (l *PluginDNS) Priority() int {
+	return l.cfg.Priority
 }
 
+func (l *PluginDNS) Zone(host string) string {
+	return l.cfg.Zone
+}
+
+func (l *PluginDNS) Transport() discoverlib.TransportOptions {
+	return l.cfg.Transport
+}
+
+func (l *PluginDNS) TLS() discoverlib.TLSOptions {
+	return l.cfg.TLS
+}
+
+func (l *PluginDNS) Hostname() string {
+	return l.cfg.Hostname
+}
+
+// Exit stops the updates and closes the channel once the running lookup
+// finishes, so there are no sends on the closed channel
 func (l *PluginDNS) Exit() {
-	l.exit.Set()
-	l.t.Stop()
-	time.Sleep(l.refresh + (time.Millisecond * 100))
+	if !l.exit.SetToIf(false, true) {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
 	close(l.C)
 }
 
 func (l *PluginDNS) interval() {
-	for range l.t.C {
-		l.update()
-		l.t.Reset(l.refresh)
+	defer l.wg.Done()
+	for {
+		select {
+		case <-l.t.C:
+			l.update()
+			l.t.Reset(l.refresh)
+		case <-l.done:
+			l.t.Stop()
+			return
+		}
 	}
 }
 
 func (l *PluginDNS) update() {
-	if hosts, err := l.get(); err == nil {
+	hosts, err := l.get()
+	l.SetError(err)
+	if err != nil {
+		discoverlib.With(l.cfg.Logger).Warn("DNS lookup failed", "hostname", l.cfg.Hostname, "error", err)
+	}
+	if err == nil {
 		for i, v := range hosts {
 			hosts[i] = v + ":" + l.cfg.Port
 		}
-		if !l.exit.IsSet() {
-			l.C <- hosts
+		select {
+		case l.C <- hosts:
+		case <-l.done:
 		}
 	}
 }
 
 func (l *PluginDNS) get() ([]string, error) {
-	return net.LookupHost(l.cfg.Hostname)
+	ctx, span := l.cfg.StartSpan(context.Background(), "dns.lookup",
+		attribute.String("dns.hostname", l.cfg.Hostname))
+	hosts, err := net.DefaultResolver.LookupHost(ctx, l.cfg.Hostname)
+	span.SetAttributes(attribute.Int("dns.addresses", len(hosts)))
+	discoverlib.EndSpan(span, err)
+	return hosts, err
 }
@@ -1,12 +1,10 @@
 package pluginK8S
 
 import (
-	"log"
 	"net/url"
 	"os/user"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gabrielperezs/discover/discoverlib"
 )
@@ -16,30 +14,34 @@ type Config struct {
 	Namespace      string
 	MasterURL      string
 	KubeConfigPath string
+	Watch          bool
 }
 
-func (c *Config) Load(u *url.URL) error {
+func (c *Config) Load(u *url.URL) (err error) {
 	for k, v := range u.Query() {
 		switch strings.ToLower(k) {
-		case "refresh":
-			c.Refresh, _ = time.ParseDuration(v[0])
 		case "namespace":
 			c.Namespace = v[0]
 		case "path":
 			c.KubeConfigPath = v[0]
-		case "weight":
-			c.Weight, _ = strconv.ParseInt(v[0], 10, 64)
+		case "watch":
+			var w bool
+			if w, err = strconv.ParseBool(v[0]); err == nil {
+				c.Watch = w
+			}
 		default:
-			log.Printf("WARN: unknown value in dns plugin %s %s", k, v)
+			var known bool
+			if known, err = c.Set(k, v[0]); !known {
+				err = discoverlib.ErrUnknownKey
+			}
+		}
+		if err = c.Check(u, k, v[0], err); err != nil {
+			return err
 		}
 	}
 
 	c.Port = u.Port()
-	for i, s := range strings.Split(u.Scheme, "+") {
-		if i == 1 {
-			c.Protocol = s
-		}
-	}
+	c.LoadProtocol(u)
 
 	if c.Namespace == "" {
 		c.Namespace = "default"
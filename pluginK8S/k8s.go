@@ -3,10 +3,12 @@ package pluginK8S
 import (
 	"context"
 	"errors"
-	"log"
+	"sync"
 	"time"
 
+	"github.com/gabrielperezs/discover/discoverlib"
 	"github.com/tevino/abool"
+	"go.opentelemetry.io/otel/attribute"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
@@ -20,29 +22,45 @@ var (
 
 var (
 	getPodsTimeout = int64(time.Duration(1 * time.Minute).Seconds())
+
+	// zoneLabels of the nodes, the first one found is the zone of its pods
+	zoneLabels = []string{
+		"topology.kubernetes.io/zone",
+		"failure-domain.beta.kubernetes.io/zone",
+	}
 )
 
 type PluginK8S struct {
+	discoverlib.StatusRecorder
 	C         chan []string
 	t         *time.Timer
 	cfg       Config
 	namespace string
 	watch     bool
 	config    *rest.Config
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 	exit      *abool.AtomicBool
+	done      chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.RWMutex
+	zones     map[string]string
 }
 
 func New(c Config) *PluginK8S {
 	l := &PluginK8S{
-		C:    make(chan []string, 1),
-		t:    time.NewTimer(c.Refresh),
-		cfg:  c,
-		exit: abool.New(),
+		C:     make(chan []string, 1),
+		t:     time.NewTimer(c.Refresh),
+		cfg:   c,
+		watch: c.Watch,
+		exit:  abool.New(),
+		done:  make(chan struct{}),
 	}
+	l.SetObserver(c.Observer)
 	if err := l.Reload(c); err != nil {
-		log.Printf("ERROR: %+v", err)
+		l.SetError(err)
+		l.log().Error("kubernetes client not loaded", "error", err)
 	}
+	l.wg.Add(1)
 	go l.interval()
 	return l
 }
@@ -57,7 +75,7 @@ func (l *PluginK8S) Reload(c Config) (err error) {
 
 	config, err := rest.InClusterConfig()
 	if err != nil {
-		panic(err.Error())
+		return err
 	}
 
 	l.clientset, err = kubernetes.NewForConfig(config)
@@ -67,6 +85,10 @@ func (l *PluginK8S) Reload(c Config) (err error) {
 	return
 }
 
+func (l *PluginK8S) log() discoverlib.Logger {
+	return discoverlib.With(l.cfg.Logger)
+}
+
 func (l *PluginK8S) Get() chan []string {
 	return l.C
 }
@@ -79,40 +101,85 @@ func (l *PluginK8S) Weight() int64 {
 	return l.cfg.Weight
 }
 
-func (l *PluginK8S) Timeout() time.Duration {
-	return l.cfg.Timeout
+func (l *PluginK8S) Priority() int {
+	return l.cfg.Priority
 }
 
+// Zone of the node of the pod, the zone of the URI if it is unknown
+func (l *PluginK8S) Zone(host string) string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if z, ok := l.zones[host]; ok && z != "" {
+		return z
+	}
+	return l.cfg.Zone
+}
+
+func (l *PluginK8S) Transport() discoverlib.TransportOptions {
+	return l.cfg.Transport
+}
+
+func (l *PluginK8S) TLS() discoverlib.TLSOptions {
+	return l.cfg.TLS
+}
+
+func (l *PluginK8S) Hostname() string {
+	return l.cfg.Hostname
+}
+
+// Exit stops the updates and closes the channel once the running list or
+// watch finishes, so there are no sends on the closed channel
 func (l *PluginK8S) Exit() {
-	l.exit.Set()
-	l.t.Stop()
-	time.Sleep(l.cfg.Refresh + (time.Millisecond * 100))
+	if !l.exit.SetToIf(false, true) {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
 	close(l.C)
 }
 
 func (l *PluginK8S) send(hosts []string) {
-	if l.exit.IsSet() == false {
-		l.C <- hosts
+	select {
+	case l.C <- hosts:
+	case <-l.done:
 	}
 }
 
 func (l *PluginK8S) interval() {
-	if hosts, err := l.once(); err == nil {
+	defer l.wg.Done()
+	hosts, err := l.once()
+	l.SetError(err)
+	if err != nil {
+		l.log().Warn("pods not listed", "namespace", l.namespace, "error", err)
+	} else {
 		l.send(hosts)
 	}
 
-	for range l.t.C {
-		l.get()
-		l.t.Reset(l.cfg.Refresh)
+	for {
+		select {
+		case <-l.t.C:
+			l.get()
+			l.t.Reset(l.cfg.Refresh)
+		case <-l.done:
+			l.t.Stop()
+			return
+		}
 	}
 }
 
-func (l *PluginK8S) once() ([]string, error) {
+func (l *PluginK8S) once() (hosts []string, err error) {
 	if l.clientset == nil {
 		return nil, ErrInvalidLogin
 	}
 
-	pods, err := l.clientset.CoreV1().Pods(l.namespace).List(context.Background(), metav1.ListOptions{
+	ctx, span := l.cfg.StartSpan(context.Background(), "k8s.list_pods",
+		attribute.String("k8s.namespace.name", l.namespace))
+	defer func() {
+		span.SetAttributes(attribute.Int("k8s.pods", len(hosts)))
+		discoverlib.EndSpan(span, err)
+	}()
+
+	pods, err := l.clientset.CoreV1().Pods(l.namespace).List(ctx, metav1.ListOptions{
 		Watch:          false,
 		TimeoutSeconds: &getPodsTimeout,
 	})
@@ -121,11 +188,39 @@ func (l *PluginK8S) once() ([]string, error) {
 	}
 
 	listIP := make([]string, 0)
+	zones := make(map[string]string)
+	nodes := make(map[string]string)
 	for _, pod := range pods.Items {
-		listIP = append(listIP, pod.Status.PodIP+":"+l.cfg.Port)
+		host := pod.Status.PodIP + ":" + l.cfg.Port
+		listIP = append(listIP, host)
+
+		zone, ok := nodes[pod.Spec.NodeName]
+		if !ok && pod.Spec.NodeName != "" {
+			zone = l.nodeZone(ctx, pod.Spec.NodeName)
+			nodes[pod.Spec.NodeName] = zone
+		}
+		zones[host] = zone
 	}
 
-	return nil, nil
+	l.mu.Lock()
+	l.zones = zones
+	l.mu.Unlock()
+
+	return listIP, nil
+}
+
+// nodeZone reads the topology labels of the node
+func (l *PluginK8S) nodeZone(ctx context.Context, name string) string {
+	node, err := l.clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return ""
+	}
+	for _, label := range zoneLabels {
+		if z := node.Labels[label]; z != "" {
+			return z
+		}
+	}
+	return ""
 }
 
 func (l *PluginK8S) get() {
@@ -134,17 +229,33 @@ func (l *PluginK8S) get() {
 	}
 
 	events := l.clientset.CoreV1().Events(l.namespace)
-	w, _ := events.Watch(context.Background(), metav1.ListOptions{
+	w, err := events.Watch(context.Background(), metav1.ListOptions{
 		Watch:          true,
 		TimeoutSeconds: &getPodsTimeout,
 	})
+	if err != nil {
+		l.SetError(err)
+		l.log().Warn("events not watched", "namespace", l.namespace, "error", err)
+		return
+	}
+	defer w.Stop()
 	for {
-		result := <-w.ResultChan()
-		if result.Type == watch.Error {
+		var result watch.Event
+		var ok bool
+		select {
+		case result, ok = <-w.ResultChan():
+		case <-l.done:
+			return
+		}
+		if !ok || result.Type == watch.Error {
 			break
 		}
 
-		if hosts, err := l.once(); err == nil {
+		hosts, err := l.once()
+		l.SetError(err)
+		if err != nil {
+			l.log().Warn("pods not listed", "namespace", l.namespace, "error", err)
+		} else {
 			l.send(hosts)
 		}
 
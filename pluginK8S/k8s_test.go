@@ -2,6 +2,11 @@ package pluginK8S
 
 import (
 	"testing"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestAuth(t *testing.T) {
@@ -26,3 +31,41 @@ func TestAuth(t *testing.T) {
 	// log.Printf("%+v", nodes)
 	return
 }
+
+func TestZones(t *testing.T) {
+	l := &PluginK8S{
+		cfg:       Config{ConfigBase: discoverlib.ConfigBase{Port: "80", Zone: "default-zone"}},
+		namespace: "default",
+		clientset: fake.NewSimpleClientset(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   "node-a",
+				Labels: map[string]string{"topology.kubernetes.io/zone": "eu-west-1a"},
+			}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "default"},
+				Spec:       corev1.PodSpec{NodeName: "node-a"},
+				Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "default"},
+				Spec:       corev1.PodSpec{NodeName: "node-b"},
+				Status:     corev1.PodStatus{PodIP: "10.0.0.2"},
+			},
+		),
+	}
+
+	hosts, err := l.once()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("Invalid hosts %v", hosts)
+	}
+	if z := l.Zone("10.0.0.1:80"); z != "eu-west-1a" {
+		t.Errorf("Invalid zone from the node labels %q", z)
+	}
+	if z := l.Zone("10.0.0.2:80"); z != "default-zone" {
+		t.Errorf("Invalid zone of the URI %q", z)
+	}
+}
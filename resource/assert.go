@@ -0,0 +1,106 @@
+package resource
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidAssertion = errors.New("Invalid JSON assertion")
+	ErrAssertionFailed  = errors.New("JSON assertion failed")
+)
+
+// jsonAssertion checks a field of a JSON document, written like
+// `$.status == "UP"`, `$.checks[0].ok != false` or just `$.status`
+// to check that the field exists
+type jsonAssertion struct {
+	expr  string
+	path  []interface{}
+	op    string
+	value interface{}
+}
+
+func parseJSONAssertion(expr string) (*jsonAssertion, error) {
+	a := &jsonAssertion{expr: expr}
+	left := strings.TrimSpace(expr)
+	// The first operator, the value could contain the other one
+	i := -1
+	for _, op := range []string{"==", "!="} {
+		if j := strings.Index(expr, op); j >= 0 && (i < 0 || j < i) {
+			i, a.op = j, op
+		}
+	}
+	if i >= 0 {
+		left = strings.TrimSpace(expr[:i])
+		if err := json.Unmarshal([]byte(strings.TrimSpace(expr[i+len(a.op):])), &a.value); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidAssertion, expr, err)
+		}
+	}
+
+	if !strings.HasPrefix(left, "$") {
+		return nil, fmt.Errorf("%w: %s: the path must start with $", ErrInvalidAssertion, expr)
+	}
+	for p := left[1:]; p != ""; {
+		switch p[0] {
+		case '.':
+			end := strings.IndexAny(p[1:], ".[") + 1
+			if end == 0 {
+				end = len(p)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf("%w: %s: empty field", ErrInvalidAssertion, expr)
+			}
+			a.path = append(a.path, p[1:end])
+			p = p[end:]
+		case '[':
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: %s: missing ]", ErrInvalidAssertion, expr)
+			}
+			i, err := strconv.Atoi(p[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %s", ErrInvalidAssertion, expr, err)
+			}
+			a.path = append(a.path, i)
+			p = p[end+1:]
+		default:
+			return nil, fmt.Errorf("%w: %s: unexpected %q", ErrInvalidAssertion, expr, p[0])
+		}
+	}
+	return a, nil
+}
+
+func (a *jsonAssertion) check(doc interface{}) error {
+	v := doc
+	for _, k := range a.path {
+		var ok bool
+		switch k := k.(type) {
+		case string:
+			var m map[string]interface{}
+			if m, ok = v.(map[string]interface{}); ok {
+				v, ok = m[k]
+			}
+		case int:
+			var l []interface{}
+			if l, ok = v.([]interface{}); ok && k >= 0 && k < len(l) {
+				v = l[k]
+			} else {
+				ok = false
+			}
+		}
+		if !ok {
+			return fmt.Errorf("%w: %s: field not found", ErrAssertionFailed, a.expr)
+		}
+	}
+
+	switch {
+	case a.op == "==" && !reflect.DeepEqual(v, a.value),
+		a.op == "!=" && reflect.DeepEqual(v, a.value):
+		return fmt.Errorf("%w: %s: got %v", ErrAssertionFailed, a.expr, v)
+	}
+	return nil
+}
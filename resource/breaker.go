@@ -0,0 +1,245 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+var (
+	// ErrCircuitOpen when the request is not sent because the circuit
+	// breaker of the resource is open
+	ErrCircuitOpen = errors.New("Circuit breaker open")
+
+	defaultBreakerFailureRatio     = 0.5
+	defaultBreakerMinRequests      = 20
+	defaultBreakerInterval         = 10 * time.Second
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerHalfOpenRequests = 1
+
+	circuitStates = []string{"closed", "open", "half-open"}
+)
+
+// CircuitBreaker configures the circuit breaker of the resources. The
+// circuit opens when the ratio of failed requests (errors and 5xx) is
+// reached, after OpenTimeout it lets HalfOpenRequests trial requests
+// through and closes again if all of them succeed. Only the requests
+// sent with Resource.RoundTrip are counted
+type CircuitBreaker struct {
+	// FailureRatio in Interval that opens the circuit if there were at
+	// least MinRequests. By default 0.5 of 20 requests in 10s
+	FailureRatio float64 `json:"failure_ratio,omitempty"`
+	MinRequests  int     `json:"min_requests,omitempty"`
+	Interval     string  `json:"interval,omitempty"`
+	// OpenTimeout before moving to half-open, 30s by default
+	OpenTimeout string `json:"open_timeout,omitempty"`
+	// HalfOpenRequests are the trial requests, 1 by default
+	HalfOpenRequests int `json:"half_open_requests,omitempty"`
+	// MaxInFlight requests in the resource, when it is reached the
+	// resource is skipped like with the circuit open. 0 is unlimited
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+}
+
+// Validate returns FieldErrors with all the invalid fields
+func (c CircuitBreaker) Validate() error {
+	var errs FieldErrors
+	for field, v := range map[string]string{
+		"interval":     c.Interval,
+		"open_timeout": c.OpenTimeout,
+	} {
+		if v != "" {
+			if _, err := time.ParseDuration(v); err != nil {
+				errs = append(errs, &FieldError{Field: field, Err: err})
+			}
+		}
+	}
+	if c.FailureRatio < 0 || c.FailureRatio > 1 {
+		errs = append(errs, &FieldError{Field: "failure_ratio", Err: fmt.Errorf("%w: %v", discoverlib.ErrInvalidValue, c.FailureRatio)})
+	}
+	if c.MinRequests < 0 {
+		errs = append(errs, &FieldError{Field: "min_requests", Err: discoverlib.ErrInvalidValue})
+	}
+	if c.HalfOpenRequests < 0 {
+		errs = append(errs, &FieldError{Field: "half_open_requests", Err: discoverlib.ErrInvalidValue})
+	}
+	if c.MaxInFlight < 0 {
+		errs = append(errs, &FieldError{Field: "max_in_flight", Err: discoverlib.ErrInvalidValue})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sortFieldErrors(errs)
+	return errs
+}
+
+type breaker struct {
+	sync.Mutex
+	host             string
+	metrics          *Metrics
+	logger           discoverlib.Logger
+	failureRatio     float64
+	minRequests      int
+	interval         time.Duration
+	openTimeout      time.Duration
+	halfOpenRequests int
+	maxInFlight      int
+
+	state int
+	// generation changes with the state, the results of the requests
+	// acquired in other state are ignored
+	generation  uint64
+	openedAt    time.Time
+	windowStart time.Time
+	requests    int
+	failures    int
+	inFlight    int
+	trials      int
+	successes   int
+}
+
+func newBreaker(host string, c CircuitBreaker, metrics *Metrics) *breaker {
+	b := &breaker{
+		host:             host,
+		metrics:          metrics,
+		failureRatio:     c.FailureRatio,
+		minRequests:      c.MinRequests,
+		interval:         duration(c.Interval, defaultBreakerInterval),
+		openTimeout:      duration(c.OpenTimeout, defaultBreakerOpenTimeout),
+		halfOpenRequests: c.HalfOpenRequests,
+		maxInFlight:      c.MaxInFlight,
+	}
+	if b.failureRatio == 0 {
+		b.failureRatio = defaultBreakerFailureRatio
+	}
+	if b.minRequests == 0 {
+		b.minRequests = defaultBreakerMinRequests
+	}
+	if b.halfOpenRequests == 0 {
+		b.halfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+	metrics.circuitState.WithLabelValues(host).Set(CircuitClosed)
+	return b
+}
+
+// CircuitStateName returns closed, open or half-open
+func CircuitStateName(state int) string {
+	if state < 0 || state >= len(circuitStates) {
+		return ""
+	}
+	return circuitStates[state]
+}
+
+func (b *breaker) setState(state int) {
+	b.state = state
+	b.generation++
+	b.trials, b.successes = 0, 0
+	if state == CircuitOpen {
+		b.openedAt = time.Now()
+	}
+	if state == CircuitClosed {
+		b.windowStart, b.requests, b.failures = time.Now(), 0, 0
+	}
+	b.metrics.record(func() {
+		b.metrics.circuitState.WithLabelValues(b.host).Set(float64(state))
+		b.metrics.circuitTransitions.WithLabelValues(b.host, circuitStates[state]).Inc()
+	})
+	discoverlib.With(b.logger).Info("circuit breaker state changed", "state", circuitStates[state])
+}
+
+// current moves from open to half-open after the timeout, it must be
+// called with the lock
+func (b *breaker) current() int {
+	if b.state == CircuitOpen && time.Since(b.openedAt) >= b.openTimeout {
+		b.setState(CircuitHalfOpen)
+	}
+	return b.state
+}
+
+func (b *breaker) State() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.current()
+}
+
+// ready is true if a new request would be allowed, it doesn't reserve it
+func (b *breaker) ready() bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.maxInFlight > 0 && b.inFlight >= b.maxInFlight {
+		return false
+	}
+	switch b.current() {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		return b.trials < b.halfOpenRequests
+	}
+	return true
+}
+
+// acquire reserves a request, the result must be given to done with the
+// generation
+func (b *breaker) acquire() (uint64, bool) {
+	b.Lock()
+	defer b.Unlock()
+	if b.maxInFlight > 0 && b.inFlight >= b.maxInFlight {
+		return 0, false
+	}
+	switch b.current() {
+	case CircuitOpen:
+		return 0, false
+	case CircuitHalfOpen:
+		if b.trials >= b.halfOpenRequests {
+			return 0, false
+		}
+		b.trials++
+	}
+	b.inFlight++
+	return b.generation, true
+}
+
+// done records the result of a request, ignored if the state changed
+// after the acquire, so only the requests of the half-open state are
+// trials
+func (b *breaker) done(generation uint64, failed bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.inFlight--
+
+	state := b.current()
+	if generation != b.generation {
+		return
+	}
+	switch state {
+	case CircuitHalfOpen:
+		if failed {
+			b.setState(CircuitOpen)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests {
+			b.setState(CircuitClosed)
+		}
+	case CircuitClosed:
+		if time.Since(b.windowStart) > b.interval {
+			b.windowStart, b.requests, b.failures = time.Now(), 0, 0
+		}
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.failureRatio {
+			b.setState(CircuitOpen)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package resource
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var fail int32 = 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	r := New(Config{
+		Plugin: &testPlugin{},
+		Host:   strings.TrimPrefix(srv.URL, "http://"),
+		CircuitBreaker: &CircuitBreaker{
+			MinRequests: 2,
+			OpenTimeout: "50ms",
+		},
+	})
+	defer r.Close()
+
+	get := func() error {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		res, err := r.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	get()
+	if r.CircuitState() != CircuitClosed {
+		t.Fatal("Opened before MinRequests")
+	}
+	get()
+	if r.CircuitState() != CircuitOpen || r.Available() {
+		t.Fatal("Circuit not open")
+	}
+	if err := get(); err != ErrCircuitOpen {
+		t.Fatalf("Expected ErrCircuitOpen, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if r.CircuitState() != CircuitHalfOpen || !r.Available() {
+		t.Fatal("Circuit not half-open")
+	}
+	// A failed trial opens it again
+	get()
+	if r.CircuitState() != CircuitOpen {
+		t.Fatal("Circuit not open after a failed trial")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&fail, 0)
+	generation, ok := r.breaker.acquire()
+	if !ok {
+		t.Fatal("Trial request not allowed")
+	}
+	if _, ok := r.breaker.acquire(); r.Available() || ok {
+		t.Error("More trial requests than HalfOpenRequests")
+	}
+	r.breaker.done(generation, false)
+	if r.CircuitState() != CircuitClosed {
+		t.Error("Circuit not closed after the trial")
+	}
+}
+
+func TestCircuitBreakerInFlight(t *testing.T) {
+	r := &Resource{
+		healthStatus: 1,
+		breaker:      newBreaker("test", CircuitBreaker{MaxInFlight: 2}, DefaultMetrics().with("")),
+	}
+	generation, _ := r.breaker.acquire()
+	r.breaker.acquire()
+	if _, ok := r.breaker.acquire(); r.Available() || ok {
+		t.Error("MaxInFlight not respected")
+	}
+	r.breaker.done(generation, false)
+	if !r.Available() {
+		t.Error("Not available after a request is done")
+	}
+}
+
+func TestCircuitBreakerStaleResult(t *testing.T) {
+	b := newBreaker("test", CircuitBreaker{MinRequests: 2, OpenTimeout: "10ms"}, DefaultMetrics().with(""))
+	// Acquired while closed, done after the circuit opens
+	stale, _ := b.acquire()
+	for i := 0; i < 2; i++ {
+		generation, _ := b.acquire()
+		b.done(generation, true)
+	}
+	if b.State() != CircuitOpen {
+		t.Fatal("Circuit not open")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	b.done(stale, false)
+	if s := b.State(); s != CircuitHalfOpen {
+		t.Fatalf("Closed by a request of the closed state: %s", CircuitStateName(s))
+	}
+	generation, ok := b.acquire()
+	if !ok {
+		t.Fatal("Trial request not allowed")
+	}
+	b.done(generation, false)
+	if b.State() != CircuitClosed {
+		t.Error("Circuit not closed after the trial")
+	}
+}
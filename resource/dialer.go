@@ -7,8 +7,8 @@ import (
 	"net"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/gabrielperezs/discover/discoverlib"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -20,6 +20,20 @@ var (
 	ErrNoHostAvailable = errors.New("No host available")
 )
 
+// dialError is an error of the dial or the TLS handshake, already
+// observed by the dialer so RoundTrip ignores it
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dialError) Unwrap() error {
+	return e.err
+}
+
 // CustomDialer implements a roundrobin connection pool based on
 // the resources in the backend. Could be IP or hosts. If is hosts
 // it will refresh the DNS resolution every 5s and will all the returned
@@ -27,8 +41,14 @@ var (
 type CustomDialer struct {
 	servername string
 	d          *net.Dialer
-	tls        *tls.Conn
+	tls        *tlsLoader
 	addr       string
+	http2      bool
+	metrics    *Metrics
+	tracer     trace.Tracer
+	// observe receives the result of the dials of the Transport for the
+	// outlier detection
+	observe func(failed bool)
 }
 
 func (cd *CustomDialer) Addr() string {
@@ -36,12 +56,16 @@ func (cd *CustomDialer) Addr() string {
 }
 
 // Create a new custom Dialer with specific hosts
-func newCustomDialer(addr string) *CustomDialer {
+func newCustomDialer(addr string, opts discoverlib.TransportOptions, tls *tlsLoader, servername string, metrics *Metrics) *CustomDialer {
 	cd := &CustomDialer{
-		addr: addr,
+		addr:       addr,
+		metrics:    metrics,
+		servername: servername,
+		tls:        tls,
+		http2:      opts.HTTP2 != nil && *opts.HTTP2,
 		d: &net.Dialer{
-			Timeout:       5 * time.Second,
-			KeepAlive:     10 * time.Second,
+			Timeout:       opts.DialTimeout,
+			KeepAlive:     opts.KeepAlive,
 			FallbackDelay: -1,
 		},
 	}
@@ -51,50 +75,58 @@ func newCustomDialer(addr string) *CustomDialer {
 // DialContext will use one IP from the resources using the round-robin
 // and call to the original net.DialContext
 func (cd *CustomDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	ctx, span := cd.startSpan(ctx, "discover.dial")
+	start := time.Now()
 	conn, err := cd.d.DialContext(ctx, network, cd.addr)
-	if err == nil {
-		statConn.WithLabelValues(cd.addr).Inc()
-		return conn, err
+	return conn, cd.done(span, start, err)
+}
+
+// DialTLSContext connects to the address of the resource and makes the
+// handshake, both steps are cancelled with the context
+func (cd *CustomDialer) DialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
+	ctx, span := cd.startSpan(ctx, "discover.dial_tls")
+	start := time.Now()
+	cfg, err := cd.tls.Config()
+	if err != nil {
+		cd.metrics.dial(cd.addr, start, err)
+		discoverlib.EndSpan(span, err)
+		return nil, &dialError{err: err}
+	}
+	cfg = cfg.Clone()
+	if cfg.ServerName == "" {
+		cfg.ServerName = cd.servername
 	}
-	switch v := err.(type) {
-	case *net.OpError:
-		statConnErr.WithLabelValues(cd.addr, v.Error()).Inc()
-	default:
-		statConnErr.WithLabelValues(cd.addr, err.Error()).Inc()
+	if cd.http2 {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
 	}
-	return conn, err
+
+	d := &tls.Dialer{
+		NetDialer: cd.d,
+		Config:    cfg,
+	}
+	conn, err := d.DialContext(ctx, network, cd.addr)
+	return conn, cd.done(span, start, err)
 }
 
-func (cd *CustomDialer) DialTLSContext(ctx context.Context, network, address string) (net.Conn, error) {
-	conn, err := tls.Dial(network, cd.addr, &tls.Config{
-		ServerName: cd.servername,
-	})
+// done records the result of a dial, the errors are returned as dialError
+func (cd *CustomDialer) done(span trace.Span, start time.Time, err error) error {
+	cd.metrics.dial(cd.addr, start, err)
+	discoverlib.EndSpan(span, err)
 	if err == nil {
-		statConn.WithLabelValues(cd.addr).Inc()
-		return conn, err
+		return nil
 	}
-	statConnErr.WithLabelValues(cd.addr, err.Error()).Inc()
-	return conn, err
+	if cd.observe != nil {
+		cd.observe(true)
+	}
+	return &dialError{err: err}
 }
 
-var (
-	statConn = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "wbrouter_backend_conns",
-		Help: "Connections to the backend",
-	}, []string{"Host"})
-
-	statConnErr = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "wbrouter_backend_conns_errors",
-		Help: "Connections errors to the backend",
-	}, []string{"Host", "Error"})
-
-	statAddrs = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "wbrouter_backend_addrs",
-		Help: "Address in the backends",
-	}, []string{"Host"})
-
-	statUnhealthyNodes = promauto.NewGaugeVec(prometheus.GaugeOpts{
-		Name: "wbrouter_backend_nodes_unhealthy",
-		Help: "Unhealthy backend nodes",
-	}, []string{"Host"})
-)
+// startSpan of a dial, only when the context already has a span, like
+// the one of the request or the health check, to avoid a trace per
+// connection
+func (cd *CustomDialer) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if cd.tracer == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(context.Background())
+	}
+	return cd.tracer.Start(ctx, name, trace.WithAttributes(peerAttributes(cd.addr)...))
+}
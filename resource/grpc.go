@@ -0,0 +1,64 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var (
+	ErrNotServing = errors.New("Not serving")
+)
+
+// checkGRPC calls the Check method of the gRPC Health Checking Protocol
+// with HealthCheck.GRPCService. The connection uses TLS when the resource
+// does
+func (r *Resource) checkGRPC(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.HealthCheck.timeout())
+	defer cancel()
+
+	opts := []grpc.DialOption{
+		grpc.WithBlock(),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return r.dialer.DialContext(ctx, "tcp", addr)
+		}),
+	}
+	if r.useTLS {
+		cfg, err := r.tls.Config()
+		if err != nil {
+			return err
+		}
+		cfg = cfg.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = r.servername
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	conn, err := grpc.DialContext(ctx, r.Host, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{
+		Service: r.HealthCheck.GRPCService,
+	})
+	if err != nil {
+		return err
+	}
+	switch res.Status {
+	case healthpb.HealthCheckResponse_SERVING:
+		return nil
+	case healthpb.HealthCheckResponse_NOT_SERVING:
+		return ErrNotServing
+	}
+	return fmt.Errorf("%w: %s", ErrNotServing, res.Status)
+}
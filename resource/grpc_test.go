@@ -0,0 +1,92 @@
+package resource
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func startGRPC(t *testing.T, opts ...grpc.ServerOption) (string, *grpc.Server) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := health.NewServer()
+	hs.SetServingStatus("api", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("db", healthpb.HealthCheckResponse_NOT_SERVING)
+
+	s := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(s, hs)
+	go s.Serve(ln)
+	return ln.Addr().String(), s
+}
+
+func TestHealthCheckGRPC(t *testing.T) {
+	addr, s := startGRPC(t)
+	defer s.Stop()
+
+	r := New(Config{
+		Plugin: &testPlugin{},
+		Host:   addr,
+	})
+	defer r.Close()
+
+	tests := []struct {
+		service string
+		err     error
+	}{
+		{"", nil},
+		{"api", nil},
+		{"db", ErrNotServing},
+	}
+	for _, tt := range tests {
+		r.HealthCheck = HealthCheck{Type: HealthCheckGRPC, GRPCService: tt.service}
+		if err := r.probe(context.Background()); !errors.Is(err, tt.err) {
+			t.Errorf("%q: expected %v, got %v", tt.service, tt.err, err)
+		}
+	}
+
+	r.HealthCheck = HealthCheck{Type: HealthCheckGRPC, GRPCService: "unknown"}
+	if err := r.probe(context.Background()); err == nil {
+		t.Error("Expected error for unknown service")
+	}
+
+	s.Stop()
+	r.HealthCheck = HealthCheck{Type: HealthCheckGRPC, Timeout: "100ms"}
+	if err := r.probe(context.Background()); err == nil {
+		t.Error("Expected error with the server stopped")
+	}
+}
+
+func TestHealthCheckGRPCTLS(t *testing.T) {
+	// Take the certificate of httptest for the gRPC server
+	srv := httptest.NewTLSServer(nil)
+	cert := srv.TLS.Certificates[0]
+	srv.Close()
+
+	addr, s := startGRPC(t, grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})))
+	defer s.Stop()
+
+	r := New(Config{
+		Plugin: &testPlugin{protocol: "https"},
+		Host:   addr,
+		TLS:    discoverlib.TLSOptions{InsecureSkipVerify: true},
+	})
+	defer r.Close()
+
+	r.HealthCheck = HealthCheck{Type: HealthCheckGRPC, GRPCService: "api"}
+	if err := r.probe(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
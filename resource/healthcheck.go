@@ -1,17 +1,375 @@
 package resource
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+// Health check types
+const (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	HealthCheckTLS  = "tls"
+	HealthCheckGRPC = "grpc"
 )
 
 var (
+	ErrUnknownHealthCheck = errors.New("Unknown health check type")
+	ErrUnexpectedStatus   = errors.New("Unexpected status code")
+	ErrUnexpectedContent  = errors.New("Unexpected content")
+	ErrCertExpiring       = errors.New("Certificate expiring")
+
 	defaultInterval = 10 * time.Second
+	defaultTimeout  = 2 * time.Second
+	// intervalJitter is the fraction of the interval added or removed
+	// randomly to every wait, so the resources don't probe in lockstep
+	intervalJitter = 0.1
 )
 
 type HealthCheck struct {
-	URL         string
-	RespCode    int
-	RespContent string
-	Interval    string
+	// Type is http (default), tcp to check that the connection can be
+	// established, tls to check the TLS handshake or grpc to use the
+	// gRPC Health Checking Protocol
+	Type string `json:"type,omitempty"`
+	URL  string `json:"url,omitempty"`
+	// Method of the http request, GET by default
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Host replaces the Host header taken from the URL
+	Host string `json:"host,omitempty"`
+	// RespCode is the expected status code, RespCodes accepts a list
+	// of codes and ranges like "200-299,304". Any 2xx by default
+	RespCode    int    `json:"resp_code,omitempty"`
+	RespCodes   string `json:"resp_codes,omitempty"`
+	RespContent string `json:"resp_content,omitempty"`
+	// RespRegexp must match the body of the response
+	RespRegexp string `json:"resp_regexp,omitempty"`
+	// RespJSON are assertions on the JSON body like `$.status == "UP"`
+	RespJSON []string `json:"resp_json,omitempty"`
+	// Interval between the probes, 10s by default with a 10% jitter. The
+	// first probe waits a random part of it
+	Interval string `json:"interval,omitempty"`
+	// Timeout of every probe, 2s by default
+	Timeout string `json:"timeout,omitempty"`
+	// HealthyThreshold and UnhealthyThreshold are the consecutive probes
+	// needed to change the status, 1 by default. The first probe sets
+	// the status without waiting for the threshold
+	HealthyThreshold   int `json:"healthy_threshold,omitempty"`
+	UnhealthyThreshold int `json:"unhealthy_threshold,omitempty"`
+	// CertExpiry fails the tls health check when the certificate of the
+	// resource expires in less than this duration, like "168h"
+	CertExpiry string `json:"cert_expiry,omitempty"`
+	// GRPCService is the service name sent in the grpc health check,
+	// empty checks the whole server
+	GRPCService string `json:"grpc_service,omitempty"`
 	exit        bool
 }
+
+func (hc HealthCheck) kind() string {
+	if hc.Type == "" {
+		return HealthCheckHTTP
+	}
+	return strings.ToLower(hc.Type)
+}
+
+// FieldError is a validation error of HealthCheck, Field is the name
+// of the field in JSON
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors contains all the invalid fields of a HealthCheck
+type FieldErrors []*FieldError
+
+func (e FieldErrors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Validate returns FieldErrors with all the invalid fields
+func (hc HealthCheck) Validate() error {
+	var errs FieldErrors
+	add := func(field string, err error) {
+		if err != nil {
+			errs = append(errs, &FieldError{Field: field, Err: err})
+		}
+	}
+
+	switch hc.kind() {
+	case HealthCheckHTTP, HealthCheckTCP, HealthCheckTLS, HealthCheckGRPC:
+	default:
+		add("type", ErrUnknownHealthCheck)
+	}
+	for field, v := range map[string]string{
+		"interval":    hc.Interval,
+		"timeout":     hc.Timeout,
+		"cert_expiry": hc.CertExpiry,
+	} {
+		if v != "" {
+			_, err := time.ParseDuration(v)
+			add(field, err)
+		}
+	}
+	if hc.RespCode != 0 && (hc.RespCode < 100 || hc.RespCode > 599) {
+		add("resp_code", fmt.Errorf("%w: %d", discoverlib.ErrInvalidValue, hc.RespCode))
+	}
+	if hc.HealthyThreshold < 0 {
+		add("healthy_threshold", discoverlib.ErrInvalidValue)
+	}
+	if hc.UnhealthyThreshold < 0 {
+		add("unhealthy_threshold", discoverlib.ErrInvalidValue)
+	}
+	_, err := parseStatusCodes(hc.RespCodes)
+	add("resp_codes", err)
+	_, err = regexp.Compile(hc.RespRegexp)
+	add("resp_regexp", err)
+	for i, expr := range hc.RespJSON {
+		_, err := parseJSONAssertion(expr)
+		add("resp_json["+strconv.Itoa(i)+"]", err)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sortFieldErrors(errs)
+	return errs
+}
+
+func sortFieldErrors(errs FieldErrors) {
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+}
+
+func (hc HealthCheck) method() string {
+	if hc.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(hc.Method)
+}
+
+// statusCodes are the ranges of valid status codes, a single code is
+// a range with the same start and end
+type statusCodes [][2]int
+
+func parseStatusCodes(s string) (statusCodes, error) {
+	codes := make(statusCodes, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		from, to := v, v
+		if i := strings.IndexByte(v, '-'); i > 0 {
+			from, to = v[:i], v[i+1:]
+		}
+		start, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil {
+			return nil, err
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil {
+			return nil, err
+		}
+		if start < 100 || end > 599 || start > end {
+			return nil, fmt.Errorf("%w: %s", discoverlib.ErrInvalidValue, v)
+		}
+		codes = append(codes, [2]int{start, end})
+	}
+	return codes, nil
+}
+
+func (c statusCodes) contains(code int) bool {
+	for _, r := range c {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// statusCodes returns RespCodes, RespCode or 2xx
+func (hc HealthCheck) statusCodes() statusCodes {
+	if codes, err := parseStatusCodes(hc.RespCodes); err == nil && len(codes) > 0 {
+		return codes
+	}
+	if hc.RespCode != 0 {
+		return statusCodes{{hc.RespCode, hc.RespCode}}
+	}
+	return statusCodes{{200, 299}}
+}
+
+// enabled is false when the resources are always healthy
+func (hc HealthCheck) enabled() bool {
+	return hc.kind() != HealthCheckHTTP || hc.URL != ""
+}
+
+func (hc HealthCheck) interval() time.Duration {
+	interval, _ := time.ParseDuration(hc.Interval)
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return interval
+}
+
+// firstWait is a random part of the interval, so the resources created
+// at the same time don't probe in lockstep
+func (hc HealthCheck) firstWait() time.Duration {
+	return time.Duration(rand.Int63n(int64(hc.interval())))
+}
+
+// wait is the interval with a random jitter
+func (hc HealthCheck) wait() time.Duration {
+	interval := hc.interval()
+	jitter := (rand.Float64()*2 - 1) * intervalJitter * float64(interval)
+	return interval + time.Duration(jitter)
+}
+
+func (hc HealthCheck) timeout() time.Duration {
+	timeout, _ := time.ParseDuration(hc.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return timeout
+}
+
+func (hc HealthCheck) healthyThreshold() int {
+	if hc.HealthyThreshold <= 0 {
+		return 1
+	}
+	return hc.HealthyThreshold
+}
+
+func (hc HealthCheck) unhealthyThreshold() int {
+	if hc.UnhealthyThreshold <= 0 {
+		return 1
+	}
+	return hc.UnhealthyThreshold
+}
+
+// probe runs one health check of the resource
+func (r *Resource) probe(ctx context.Context) error {
+	switch r.HealthCheck.kind() {
+	case HealthCheckHTTP:
+		if r.HealthCheck.URL == "" {
+			return nil
+		}
+		return r.checkHTTP(ctx)
+	case HealthCheckTCP:
+		return r.checkTCP(ctx)
+	case HealthCheckTLS:
+		return r.checkTLS(ctx)
+	case HealthCheckGRPC:
+		return r.checkGRPC(ctx)
+	}
+	return ErrUnknownHealthCheck
+}
+
+func (r *Resource) checkTCP(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.HealthCheck.timeout())
+	defer cancel()
+	conn, err := r.dialer.DialContext(ctx, "tcp", r.Host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (r *Resource) checkTLS(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, r.HealthCheck.timeout())
+	defer cancel()
+	conn, err := r.dialer.DialTLSContext(ctx, "tcp", r.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	expiry, _ := time.ParseDuration(r.HealthCheck.CertExpiry)
+	if expiry <= 0 {
+		return nil
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		certs := tc.ConnectionState().PeerCertificates
+		if len(certs) > 0 && time.Until(certs[0].NotAfter) < expiry {
+			return ErrCertExpiring
+		}
+	}
+	return nil
+}
+
+// checkBody verifies RespContent, RespRegexp and RespJSON
+func (hc HealthCheck) checkBody(body io.Reader) error {
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	if hc.RespContent != "" && !bytes.Contains(b, []byte(hc.RespContent)) {
+		return ErrUnexpectedContent
+	}
+
+	if hc.RespRegexp != "" {
+		re, err := regexp.Compile(hc.RespRegexp)
+		if err != nil {
+			return err
+		}
+		if !re.Match(b) {
+			return ErrUnexpectedContent
+		}
+	}
+
+	if len(hc.RespJSON) > 0 {
+		var doc interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return fmt.Errorf("%w: %s", ErrUnexpectedContent, err)
+		}
+		for _, expr := range hc.RespJSON {
+			a, err := parseJSONAssertion(expr)
+			if err != nil {
+				return err
+			}
+			if err := a.check(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckResult of the last probe of the health check
+type CheckResult struct {
+	Time time.Time
+	Err  error
+}
+
+// LastCheck returns the result of the last probe, the zero value if the
+// health check is disabled or didn't run yet
+func (r *Resource) LastCheck() CheckResult {
+	c, _ := r.lastCheck.Load().(CheckResult)
+	return c
+}
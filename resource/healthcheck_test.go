@@ -0,0 +1,216 @@
+package resource
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+func TestHealthThresholds(t *testing.T) {
+	r := &Resource{
+		HealthCheck: HealthCheck{HealthyThreshold: 2, UnhealthyThreshold: 3},
+	}
+
+	steps := []struct {
+		ok      bool
+		healthy bool
+	}{
+		{true, true}, // the first probe sets the status
+		{false, true},
+		{false, true},
+		{true, true},
+		{false, true},
+		{false, true},
+		{false, false},
+		{true, false},
+		{false, false},
+		{true, false},
+		{true, true},
+	}
+	for i, s := range steps {
+		if healthy := r.setHealth(s.ok); healthy != s.healthy {
+			t.Errorf("step %d: healthy %v != %v", i, healthy, s.healthy)
+		}
+	}
+}
+
+func TestHealthCheckWait(t *testing.T) {
+	hc := HealthCheck{Interval: "10s"}
+	min, max := 9*time.Second, 11*time.Second
+	for i := 0; i < 100; i++ {
+		if w := hc.wait(); w < min || w > max {
+			t.Fatalf("Wait out of range: %v", w)
+		}
+	}
+
+	// The first probes are spread over the interval
+	first := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		w := hc.firstWait()
+		if w < 0 || w >= 10*time.Second {
+			t.Fatalf("First wait out of range: %v", w)
+		}
+		first[w/time.Second] = true
+	}
+	if len(first) < 5 {
+		t.Errorf("First waits not spread: %v", first)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	r := New(Config{
+		Plugin: &testPlugin{},
+		Host:   strings.TrimPrefix(srv.URL, "http://"),
+	})
+	defer r.Close()
+
+	r.HealthCheck = HealthCheck{URL: "/", RespCode: http.StatusOK}
+	if !r.doHealthCheck() {
+		t.Error("Health check failed with the default timeout")
+	}
+
+	r.HealthCheck.Timeout = "50ms"
+	if r.doHealthCheck() {
+		t.Error("Health check didn't time out")
+	}
+}
+
+func TestHealthCheckTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+
+	r := New(Config{
+		Plugin: &testPlugin{},
+		Host:   addr,
+	})
+	defer r.Close()
+
+	r.HealthCheck = HealthCheck{Type: HealthCheckTCP, Timeout: "100ms"}
+	if err := r.probe(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	ln.Close()
+	if err := r.probe(context.Background()); err == nil {
+		t.Error("Expected error with the listener closed")
+	}
+}
+
+func TestHealthCheckTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	r := New(Config{
+		Plugin: &testPlugin{},
+		Host:   strings.TrimPrefix(srv.URL, "https://"),
+		TLS:    discoverlib.TLSOptions{InsecureSkipVerify: true},
+	})
+	defer r.Close()
+
+	r.HealthCheck = HealthCheck{Type: HealthCheckTLS}
+	if err := r.probe(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	// The certificate of httptest expires in 2084
+	r.HealthCheck.CertExpiry = "876000h"
+	if err := r.probe(context.Background()); err != ErrCertExpiring {
+		t.Errorf("Expected ErrCertExpiring, got %v", err)
+	}
+
+	r.HealthCheck.Type = "udp"
+	if err := r.probe(context.Background()); err != ErrUnknownHealthCheck {
+		t.Errorf("Expected ErrUnknownHealthCheck, got %v", err)
+	}
+}
+
+func TestHealthCheckHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead && r.Header.Get("X-Check") != "1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Host == "api.internal" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte(`{"status": "UP", "checks": [{"name": "db", "ok": true}], "version": 3}`))
+	}))
+	defer srv.Close()
+
+	r := New(Config{
+		Plugin: &testPlugin{},
+		Host:   strings.TrimPrefix(srv.URL, "http://"),
+	})
+	defer r.Close()
+
+	headers := map[string]string{"X-Check": "1"}
+	tests := []struct {
+		name string
+		hc   HealthCheck
+		err  error
+	}{
+		{"default status", HealthCheck{URL: "/"}, ErrUnexpectedStatus},
+		{"method", HealthCheck{URL: "/", Method: "head"}, nil},
+		{"headers", HealthCheck{URL: "/", Headers: headers}, nil},
+		{"exact code", HealthCheck{URL: "/", Headers: headers, RespCode: 201}, ErrUnexpectedStatus},
+		{"host", HealthCheck{URL: "/", Headers: headers, Host: "api.internal", RespCodes: "200, 204-206"}, nil},
+		{"regexp", HealthCheck{URL: "/", Headers: headers, RespRegexp: `"version": \d+`}, nil},
+		{"regexp fails", HealthCheck{URL: "/", Headers: headers, RespRegexp: `"status": "DOWN"`}, ErrUnexpectedContent},
+		{"json", HealthCheck{URL: "/", Headers: headers, RespJSON: []string{
+			`$.status == "UP"`, `$.checks[0].ok == true`, `$.version != 2`, `$.checks[0].name`,
+		}}, nil},
+		{"json fails", HealthCheck{URL: "/", Headers: headers, RespJSON: []string{`$.version == 2`}}, ErrAssertionFailed},
+		{"json missing", HealthCheck{URL: "/", Headers: headers, RespJSON: []string{`$.checks[1].ok`}}, ErrAssertionFailed},
+	}
+	for _, tt := range tests {
+		r.HealthCheck = tt.hc
+		if err := r.probe(context.Background()); !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestHealthCheckValidate(t *testing.T) {
+	hc := HealthCheck{
+		Type:      "http",
+		Interval:  "often",
+		RespCodes: "200-199",
+		RespJSON:  []string{`$.status == "UP"`, `status == "UP"`, `$.a[x] == 1`, `$.a == UP`},
+	}
+	var errs FieldErrors
+	if !errors.As(hc.Validate(), &errs) {
+		t.Fatal("Expected FieldErrors")
+	}
+	fields := []string{"interval", "resp_codes", "resp_json[1]", "resp_json[2]", "resp_json[3]"}
+	if len(errs) != len(fields) {
+		t.Fatalf("Expected %d errors, got %v", len(fields), errs)
+	}
+	for i, f := range fields {
+		if errs[i].Field != f {
+			t.Errorf("error %v != %v", errs[i].Field, f)
+		}
+	}
+
+	if err := (HealthCheck{RespCodes: "200-299,304", RespJSON: []string{`$.a != "x==y"`}}).Validate(); err != nil {
+		t.Error(err)
+	}
+	if !errors.As((HealthCheck{HealthyThreshold: -1}).Validate(), &errs) || !errors.Is(errs[0], discoverlib.ErrInvalidValue) {
+		t.Errorf("Expected discoverlib.ErrInvalidValue, got %v", errs)
+	}
+}
@@ -0,0 +1,287 @@
+package resource
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gabrielperezs/discover/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultNamespace of the metrics when it isn't configured
+const DefaultNamespace = "wbrouter"
+
+// Classes of the connection errors used as label of the metrics
+const (
+	ErrorClassTimeout  = "timeout"
+	ErrorClassRefused  = "refused"
+	ErrorClassDNS      = "dns"
+	ErrorClassTLS      = "tls"
+	ErrorClassReset    = "reset"
+	ErrorClassCanceled = "canceled"
+	ErrorClassOther    = "other"
+)
+
+var (
+	// ErrMetricType when a metric is already registered with other type
+	ErrMetricType = registry.ErrMetricType
+
+	defaultMetrics     *Metrics
+	defaultMetricsOnce sync.Once
+
+	errorClasses = []string{
+		ErrorClassTimeout,
+		ErrorClassRefused,
+		ErrorClassDNS,
+		ErrorClassTLS,
+		ErrorClassReset,
+		ErrorClassCanceled,
+		ErrorClassOther,
+	}
+	checkResults = []string{"success", "failure"}
+)
+
+// seriesKey identifies the series of a resource in the vectors of a
+// registry, id is shared by all the Metrics of the same registry
+type seriesKey struct {
+	id    *prometheus.CounterVec
+	label string
+	host  string
+}
+
+// Metrics are the metric vectors of the resources, labelled by the Label
+// of the Discover and the Host. The series of a resource are deleted when
+// the last resource using them is closed
+type Metrics struct {
+	// id is the conns vector before setting the Label
+	id    *prometheus.CounterVec
+	label string
+	// released is set when the resource is closed, the series are not
+	// written again after it
+	mu                 sync.RWMutex
+	released           bool
+	conns              *prometheus.CounterVec
+	connErrors         *prometheus.CounterVec
+	dialDuration       *prometheus.HistogramVec
+	ejections          *prometheus.CounterVec
+	circuitState       *prometheus.GaugeVec
+	circuitTransitions *prometheus.CounterVec
+	unhealthyNodes     *prometheus.GaugeVec
+	checks             *prometheus.CounterVec
+	checkDuration      *prometheus.HistogramVec
+}
+
+// NewMetrics registers the metrics in reg with the namespace as prefix.
+// If they were already registered, by another Discover with the same
+// registry, the existing ones are used. Other metrics with the same names
+// return the error of the registry or ErrMetricType
+func NewMetrics(reg prometheus.Registerer, namespace string) (*Metrics, error) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	r := &registry.Registration{Registerer: reg}
+	m := &Metrics{
+		conns: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_conns",
+			Help:      "Connections to the backend",
+		}, []string{"Label", "Host"})),
+		connErrors: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_conns_errors",
+			Help:      "Connections errors to the backend by class: timeout, refused, dns, tls, reset, canceled or other",
+		}, []string{"Label", "Host", "Error"})),
+		dialDuration: r.HistogramVec(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_dial_duration_seconds",
+			Help:      "Time to connect to the backend, including the TLS handshake",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"Label", "Host"})),
+		ejections: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_ejections",
+			Help:      "Ejections of the backend by the outlier detection",
+		}, []string{"Label", "Host"})),
+		circuitState: r.GaugeVec(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backend_circuit_state",
+			Help:      "Circuit breaker state of the backend: 0 closed, 1 open, 2 half-open",
+		}, []string{"Label", "Host"})),
+		circuitTransitions: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_circuit_transitions",
+			Help:      "Circuit breaker state changes of the backend",
+		}, []string{"Label", "Host", "State"})),
+		unhealthyNodes: r.GaugeVec(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backend_nodes_unhealthy",
+			Help:      "1 when the backend node is unhealthy",
+		}, []string{"Label", "Host"})),
+		checks: r.CounterVec(prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "backend_health_checks",
+			Help:      "Health check probes of the backend by result",
+		}, []string{"Label", "Host", "Result"})),
+		checkDuration: r.HistogramVec(prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "backend_health_check_duration_seconds",
+			Help:      "Duration of the health check probes of the backend",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"Label", "Host"})),
+	}
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	m.id = m.conns
+	return m, nil
+}
+
+// with returns the metrics with the Label of the Discover set, so the
+// vectors are used with the Host and the other labels
+func (m *Metrics) with(label string) *Metrics {
+	l := prometheus.Labels{"Label": label}
+	return &Metrics{
+		id:                 m.id,
+		label:              label,
+		conns:              m.conns.MustCurryWith(l),
+		connErrors:         m.connErrors.MustCurryWith(l),
+		dialDuration:       m.dialDuration.MustCurryWith(l).(*prometheus.HistogramVec),
+		ejections:          m.ejections.MustCurryWith(l),
+		circuitState:       m.circuitState.MustCurryWith(l),
+		circuitTransitions: m.circuitTransitions.MustCurryWith(l),
+		unhealthyNodes:     m.unhealthyNodes.MustCurryWith(l),
+		checks:             m.checks.MustCurryWith(l),
+		checkDuration:      m.checkDuration.MustCurryWith(l).(*prometheus.HistogramVec),
+	}
+}
+
+// acquire counts the resource as a user of the series of the host
+func (m *Metrics) acquire(host string) {
+	registry.Acquire(seriesKey{id: m.id, label: m.label, host: host})
+}
+
+// release deletes the series of the host if no other resource uses them,
+// the next updates of the resource are ignored
+func (m *Metrics) release(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.released {
+		return
+	}
+	m.released = true
+	if registry.Release(seriesKey{id: m.id, label: m.label, host: host}) {
+		m.delete(host)
+	}
+}
+
+// record runs f to update the series unless the resource was closed
+func (m *Metrics) record(f func()) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.released {
+		f()
+	}
+}
+
+// DefaultMetrics are registered in the default Prometheus registry with
+// the DefaultNamespace. If other metrics use the same names they are
+// registered in a new registry, so they work but are not exported
+func DefaultMetrics() *Metrics {
+	defaultMetricsOnce.Do(func() {
+		var err error
+		if defaultMetrics, err = NewMetrics(prometheus.DefaultRegisterer, DefaultNamespace); err != nil {
+			defaultMetrics, _ = NewMetrics(prometheus.NewRegistry(), DefaultNamespace)
+		}
+	})
+	return defaultMetrics
+}
+
+// ErrorClass returns the class of a connection error used in the metrics
+func ErrorClass(err error) string {
+	var (
+		dnsErr     *net.DNSError
+		certErr    x509.CertificateInvalidError
+		unknownErr x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		recordErr  tls.RecordHeaderError
+		netErr     net.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ErrorClassReset
+	case errors.As(err, &certErr), errors.As(err, &unknownErr), errors.As(err, &hostErr),
+		errors.As(err, &recordErr), errors.Is(err, ErrInvalidCA):
+		return ErrorClassTLS
+	}
+	return ErrorClassOther
+}
+
+// dial observes the result of a connection, the dialers without metrics
+// are ignored
+func (m *Metrics) dial(host string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+	m.record(func() {
+		m.dialDuration.WithLabelValues(host).Observe(time.Since(start).Seconds())
+		if err != nil {
+			m.connErrors.WithLabelValues(host, ErrorClass(err)).Inc()
+			return
+		}
+		m.conns.WithLabelValues(host).Inc()
+	})
+}
+
+// check observes a probe of the health check and the resulting status
+func (m *Metrics) check(host string, d time.Duration, err error, healthy bool) {
+	result := checkResults[0]
+	if err != nil {
+		result = checkResults[1]
+	}
+	unhealthy := 1.0
+	if healthy {
+		unhealthy = 0
+	}
+	m.record(func() {
+		m.checkDuration.WithLabelValues(host).Observe(d.Seconds())
+		m.checks.WithLabelValues(host, result).Inc()
+		m.unhealthyNodes.WithLabelValues(host).Set(unhealthy)
+	})
+}
+
+// delete removes the series of the host, use release to keep the ones of
+// other resources
+func (m *Metrics) delete(host string) {
+	m.conns.DeleteLabelValues(host)
+	m.dialDuration.DeleteLabelValues(host)
+	m.ejections.DeleteLabelValues(host)
+	m.circuitState.DeleteLabelValues(host)
+	m.unhealthyNodes.DeleteLabelValues(host)
+	m.checkDuration.DeleteLabelValues(host)
+	for _, state := range circuitStates {
+		m.circuitTransitions.DeleteLabelValues(host, state)
+	}
+	for _, class := range errorClasses {
+		m.connErrors.DeleteLabelValues(host, class)
+	}
+	for _, result := range checkResults {
+		m.checks.DeleteLabelValues(host, result)
+	}
+}
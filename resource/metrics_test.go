@@ -0,0 +1,199 @@
+package resource
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func series(t *testing.T, reg *prometheus.Registry, name string) int {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() == name {
+			return len(f.GetMetric())
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registering again reuses the same vectors
+	if again, err := NewMetrics(reg, "test"); err != nil || again.connErrors != m.connErrors {
+		t.Errorf("Metrics not reused: %v", err)
+	}
+
+	r := New(Config{
+		Plugin:         &testPlugin{},
+		Host:           addr,
+		Metrics:        m,
+		Label:          "api",
+		CircuitBreaker: &CircuitBreaker{},
+	})
+	client := &http.Client{Transport: r.Transport}
+	if _, err := client.Get("http://" + addr); err == nil {
+		t.Fatal("Expected connection error")
+	}
+	// One probe, without the random wait of the first one
+	r.HealthCheck = HealthCheck{Type: HealthCheckTCP}
+	r.doHealthCheck()
+
+	names := []string{
+		"test_backend_conns_errors",
+		"test_backend_circuit_state",
+		"test_backend_dial_duration_seconds",
+		"test_backend_health_checks",
+		"test_backend_health_check_duration_seconds",
+		"test_backend_nodes_unhealthy",
+	}
+	for _, name := range names {
+		if n := series(t, reg, name); n != 1 {
+			t.Errorf("Expected one series of %s, got %d", name, n)
+		}
+	}
+	// The connection of the client and the one of the health check
+	if v := testutil.ToFloat64(m.connErrors.WithLabelValues("api", addr, ErrorClassRefused)); v != 2 {
+		t.Errorf("Expected two refused connections, got %v", v)
+	}
+	if v := testutil.ToFloat64(m.unhealthyNodes.WithLabelValues("api", addr)); v != 1 {
+		t.Errorf("Invalid unhealthy status %v", v)
+	}
+	if families, _ := reg.Gather(); len(families) > 0 && !strings.HasPrefix(families[0].GetName(), "test_") {
+		t.Errorf("Namespace not used %s", families[0].GetName())
+	}
+
+	// Other resource of the same Label and Host, like the one of a
+	// reloaded backend, keeps the series when the first one is closed
+	other := New(Config{
+		Plugin:         &testPlugin{},
+		Host:           addr,
+		Metrics:        m,
+		Label:          "api",
+		CircuitBreaker: &CircuitBreaker{},
+	})
+	r.Close()
+	r.Close()
+	if n := series(t, reg, "test_backend_circuit_state"); n != 1 {
+		t.Errorf("Series of the other resource deleted: %d", n)
+	}
+
+	other.Close()
+	for _, name := range names {
+		if n := series(t, reg, name); n != 0 {
+			t.Errorf("Series of %s not deleted: %d", name, n)
+		}
+	}
+}
+
+func TestMetricsClosedDuringProbe(t *testing.T) {
+	// The probe waits for a response that never comes
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	addr := ln.Addr().String()
+
+	reg := prometheus.NewRegistry()
+	m, err := NewMetrics(reg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(Config{
+		Plugin:      &testPlugin{},
+		Host:        addr,
+		Metrics:     m,
+		HealthCheck: HealthCheck{URL: "http://" + addr + "/health", Interval: "10ms", Timeout: "5s"},
+	})
+	conn := <-accepted
+	defer conn.Close()
+
+	r.Close()
+	deadline := time.Now().Add(time.Second)
+	for r.LastCheck().Time.IsZero() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if r.LastCheck().Time.IsZero() {
+		t.Fatal("Probe not cancelled by Close")
+	}
+	for _, name := range []string{"test_backend_conns", "test_backend_health_checks", "test_backend_nodes_unhealthy"} {
+		if n := series(t, reg, name); n != 0 {
+			t.Errorf("Series of %s written after Close: %d", name, n)
+		}
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{&net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}, ErrorClassRefused},
+		{&net.OpError{Op: "read", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}, ErrorClassReset},
+		{&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.invalid"}}, ErrorClassDNS},
+		{&net.OpError{Op: "dial", Err: context.DeadlineExceeded}, ErrorClassTimeout},
+		{context.Canceled, ErrorClassCanceled},
+		{x509.UnknownAuthorityError{}, ErrorClassTLS},
+		{ErrInvalidCA, ErrorClassTLS},
+		{errors.New("dial 10.0.0.1:1234: something"), ErrorClassOther},
+	}
+	for _, tt := range tests {
+		if c := ErrorClass(tt.err); c != tt.class {
+			t.Errorf("%v: expected %s, got %s", tt.err, tt.class, c)
+		}
+	}
+}
+
+func TestMetricsCollision(t *testing.T) {
+	// Same name and labels with other type
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "test",
+		Name:      "backend_circuit_state",
+		Help:      "Circuit breaker state of the backend: 0 closed, 1 open, 2 half-open",
+	}, []string{"Label", "Host"}))
+	if _, err := NewMetrics(reg, "test"); !errors.Is(err, ErrMetricType) {
+		t.Errorf("Expected ErrMetricType, got %v", err)
+	}
+
+	// Same name with other labels
+	reg = prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "test",
+		Name:      "backend_conns",
+		Help:      "Connections to the backend",
+	}, []string{"Cluster"}))
+	if m, err := NewMetrics(reg, "test"); err == nil || m != nil {
+		t.Errorf("Expected error, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+var (
+	defaultOutlierInterval     = 10 * time.Second
+	defaultBaseEjectionTime    = 30 * time.Second
+	defaultMaxEjectionTime     = 5 * time.Minute
+	defaultMaxEjectionPercent  = 10
+	defaultOutlierMinRequests  = 10
+	defaultOutlierErrorRate    = 0.5
+	defaultConsecutiveFailures = maxErrors
+)
+
+// OutlierDetection configures the passive health checks. The resources
+// are ejected when the requests sent with Resource.RoundTrip fail with
+// connection errors, timeouts or 5xx responses. The requests sent
+// directly with the Transport only report the dial errors
+type OutlierDetection struct {
+	// ConsecutiveErrors ejects the resource after this number of
+	// failures in a row, 3 by default
+	ConsecutiveErrors int `json:"consecutive_errors,omitempty"`
+	// ErrorRate ejects the resource when the ratio of failures in
+	// Interval is equal or greater, if there were at least MinRequests.
+	// By default 0.5 of 10 requests in 10s
+	ErrorRate   float64 `json:"error_rate,omitempty"`
+	MinRequests int     `json:"min_requests,omitempty"`
+	Interval    string  `json:"interval,omitempty"`
+	// BaseEjectionTime is doubled on every new ejection of the same
+	// resource up to MaxEjectionTime. By default 30s and 5m
+	BaseEjectionTime string `json:"base_ejection_time,omitempty"`
+	MaxEjectionTime  string `json:"max_ejection_time,omitempty"`
+	// MaxEjectionPercent of the resources that can be ejected at the
+	// same time, 10 by default. One resource can always be ejected if
+	// there are more than one
+	MaxEjectionPercent int `json:"max_ejection_percent,omitempty"`
+}
+
+// Validate returns FieldErrors with all the invalid fields
+func (o OutlierDetection) Validate() error {
+	var errs FieldErrors
+	for field, v := range map[string]string{
+		"interval":           o.Interval,
+		"base_ejection_time": o.BaseEjectionTime,
+		"max_ejection_time":  o.MaxEjectionTime,
+	} {
+		if v != "" {
+			if _, err := time.ParseDuration(v); err != nil {
+				errs = append(errs, &FieldError{Field: field, Err: err})
+			}
+		}
+	}
+	if o.ConsecutiveErrors < 0 {
+		errs = append(errs, &FieldError{Field: "consecutive_errors", Err: discoverlib.ErrInvalidValue})
+	}
+	if o.ErrorRate < 0 || o.ErrorRate > 1 {
+		errs = append(errs, &FieldError{Field: "error_rate", Err: fmt.Errorf("%w: %v", discoverlib.ErrInvalidValue, o.ErrorRate)})
+	}
+	if o.MinRequests < 0 {
+		errs = append(errs, &FieldError{Field: "min_requests", Err: discoverlib.ErrInvalidValue})
+	}
+	if o.MaxEjectionPercent < 0 || o.MaxEjectionPercent > 100 {
+		errs = append(errs, &FieldError{Field: "max_ejection_percent", Err: discoverlib.ErrInvalidValue})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sortFieldErrors(errs)
+	return errs
+}
+
+func duration(s string, def time.Duration) time.Duration {
+	d, _ := time.ParseDuration(s)
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// OutlierDetector is shared by the resources of a Discover, it knows how
+// many of them are ejected to respect MaxEjectionPercent
+type OutlierDetector struct {
+	mu                 sync.Mutex
+	resources          map[*Resource]struct{}
+	consecutiveErrors  int
+	errorRate          float64
+	minRequests        int
+	interval           time.Duration
+	baseEjectionTime   time.Duration
+	maxEjectionTime    time.Duration
+	maxEjectionPercent int
+}
+
+func NewOutlierDetector(c OutlierDetection) *OutlierDetector {
+	o := &OutlierDetector{
+		resources:          make(map[*Resource]struct{}),
+		consecutiveErrors:  c.ConsecutiveErrors,
+		errorRate:          c.ErrorRate,
+		minRequests:        c.MinRequests,
+		interval:           duration(c.Interval, defaultOutlierInterval),
+		baseEjectionTime:   duration(c.BaseEjectionTime, defaultBaseEjectionTime),
+		maxEjectionTime:    duration(c.MaxEjectionTime, defaultMaxEjectionTime),
+		maxEjectionPercent: c.MaxEjectionPercent,
+	}
+	if o.consecutiveErrors == 0 {
+		o.consecutiveErrors = defaultConsecutiveFailures
+	}
+	if o.errorRate == 0 {
+		o.errorRate = defaultOutlierErrorRate
+	}
+	if o.minRequests == 0 {
+		o.minRequests = defaultOutlierMinRequests
+	}
+	if o.maxEjectionPercent == 0 {
+		o.maxEjectionPercent = defaultMaxEjectionPercent
+	}
+	return o
+}
+
+func (o *OutlierDetector) add(r *Resource) {
+	o.mu.Lock()
+	o.resources[r] = struct{}{}
+	o.mu.Unlock()
+}
+
+func (o *OutlierDetector) remove(r *Resource) {
+	o.mu.Lock()
+	delete(o.resources, r)
+	o.mu.Unlock()
+}
+
+// eject marks the resource as ejected if the ejected resources are
+// below MaxEjectionPercent
+func (o *OutlierDetector) eject(r *Resource, s *outlierState) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	ejected := 0
+	for v := range o.resources {
+		if v.IsEjected() {
+			ejected++
+		}
+	}
+	max := len(o.resources) * o.maxEjectionPercent / 100
+	if max < 1 && len(o.resources) > 1 {
+		max = 1
+	}
+	if ejected >= max {
+		return false
+	}
+
+	now := time.Now()
+	// The ejections are forgotten after being healthy for the maximum time
+	if now.Sub(s.lastEjection) > o.maxEjectionTime+s.ejectionTime {
+		s.ejections = 0
+	}
+	s.ejectionTime = time.Duration(float64(o.baseEjectionTime) * math.Pow(2, float64(s.ejections)))
+	if s.ejectionTime > o.maxEjectionTime {
+		s.ejectionTime = o.maxEjectionTime
+	}
+	s.ejections++
+	s.lastEjection = now
+	until := now.Add(s.ejectionTime)
+	atomic.StoreInt64(&r.ejectedUntil, until.UnixNano())
+	r.recovered(until)
+	r.metrics.record(func() {
+		r.metrics.ejections.WithLabelValues(r.Host).Inc()
+	})
+	r.log().Warn("resource ejected", "duration", s.ejectionTime.String())
+	return true
+}
+
+// outlierState are the results of the requests of one resource
+type outlierState struct {
+	sync.Mutex
+	consecutive  int
+	requests     int
+	failures     int
+	windowStart  time.Time
+	ejections    int
+	ejectionTime time.Duration
+	lastEjection time.Time
+}
+
+// observe records the result of a request or a connection
+func (r *Resource) observe(failed bool) {
+	if r.outliers == nil {
+		return
+	}
+	s := &r.outlierState
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.windowStart) > r.outliers.interval {
+		s.windowStart, s.requests, s.failures = now, 0, 0
+	}
+	s.requests++
+	if !failed {
+		s.consecutive = 0
+		return
+	}
+	s.failures++
+	s.consecutive++
+
+	if r.IsEjected() {
+		return
+	}
+	rate := float64(s.failures) / float64(s.requests)
+	if s.consecutive >= r.outliers.consecutiveErrors ||
+		(s.requests >= r.outliers.minRequests && rate >= r.outliers.errorRate) {
+		if r.outliers.eject(r, s) {
+			s.consecutive, s.requests, s.failures = 0, 0, 0
+		}
+	}
+}
+
+// observeRoundTrip records the result of a request, the dial errors are
+// already recorded by the dialer
+func (r *Resource) observeRoundTrip(code int, err error) {
+	if err != nil {
+		var dial *dialError
+		if errors.As(err, &dial) {
+			return
+		}
+		r.observe(true)
+		return
+	}
+	r.observe(code >= 500)
+}
+
+// IsEjected is true while the resource is ejected by the outlier detection
+func (r *Resource) IsEjected() bool {
+	until := atomic.LoadInt64(&r.ejectedUntil)
+	return until > 0 && time.Now().UnixNano() < until
+}
@@ -0,0 +1,127 @@
+package resource
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	o := NewOutlierDetector(OutlierDetection{
+		ConsecutiveErrors: 2,
+		BaseEjectionTime:  "50ms",
+	})
+	resources := make([]*Resource, 3)
+	for i := range resources {
+		resources[i] = New(Config{Plugin: &testPlugin{}, Host: host, Outliers: o})
+		defer resources[i].Close()
+	}
+
+	get := func(r *Resource) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if res, err := r.RoundTrip(req); err == nil {
+			res.Body.Close()
+		}
+	}
+
+	r := resources[0]
+	get(r)
+	if r.IsEjected() {
+		t.Fatal("Ejected before ConsecutiveErrors")
+	}
+	get(r)
+	if !r.IsEjected() || r.Available() {
+		t.Fatal("Not ejected after ConsecutiveErrors")
+	}
+	if r.outlierState.ejectionTime != 50*time.Millisecond {
+		t.Errorf("Invalid ejection time %v", r.outlierState.ejectionTime)
+	}
+
+	// Only one of three resources can be ejected
+	get(resources[1])
+	get(resources[1])
+	if resources[1].IsEjected() {
+		t.Error("MaxEjectionPercent not respected")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if r.IsEjected() {
+		t.Fatal("Still ejected after the ejection time")
+	}
+	get(r)
+	get(r)
+	if !r.IsEjected() || r.outlierState.ejectionTime != 100*time.Millisecond {
+		t.Errorf("Ejection time not doubled: %v", r.outlierState.ejectionTime)
+	}
+}
+
+func TestOutlierDetectionDialErrors(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	o := NewOutlierDetector(OutlierDetection{})
+	r := New(Config{Plugin: &testPlugin{}, Host: addr, Outliers: o})
+	defer r.Close()
+	other := New(Config{Plugin: &testPlugin{}, Host: addr, Outliers: o})
+	defer other.Close()
+
+	client := &http.Client{Transport: r.Transport}
+	for i := 0; i < defaultConsecutiveFailures; i++ {
+		if _, err := client.Get("http://" + addr); err == nil {
+			t.Fatal("Expected connection error")
+		}
+	}
+	if !r.IsEjected() {
+		t.Error("Not ejected after connection errors")
+	}
+	if other.IsEjected() {
+		t.Error("Other resource ejected")
+	}
+}
+
+func TestOutlierDetectionHandshakeErrors(t *testing.T) {
+	// Plain TCP, the TLS handshake fails
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			conn.Close()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	r := New(Config{Plugin: &testPlugin{}, Host: addr, UseTLS: true, Outliers: NewOutlierDetector(OutlierDetection{})})
+	defer r.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "https://"+addr, nil)
+	if _, err := r.RoundTrip(req); err == nil {
+		t.Fatal("Expected handshake error")
+	}
+	r.outlierState.Lock()
+	consecutive, requests := r.outlierState.consecutive, r.outlierState.requests
+	r.outlierState.Unlock()
+	if consecutive != 1 || requests != 1 {
+		t.Errorf("Handshake error observed more than once: consecutive=%d requests=%d", consecutive, requests)
+	}
+}
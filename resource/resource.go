@@ -1,18 +1,20 @@
 package resource
 
 import (
-	"bytes"
-	"crypto/tls"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync/atomic"
 	"time"
-	"unsafe"
 
 	"github.com/gabrielperezs/discover/discoverlib"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -21,42 +23,152 @@ const (
 	defaultResponseHeaderTimeout = time.Second * 60
 	defaultExpectContinueTimeout = time.Second * 1
 	defaultIdleConnTimeout       = time.Second * 30
+	defaultDialTimeout           = time.Second * 5
+	defaultKeepAlive             = time.Second * 10
+	defaultMaxIdleConns          = 100
 	defaultRateLimit             = 8
 )
 
+type Config struct {
+	Plugin      discoverlib.Plugin
+	Host        string
+	UseTLS      bool
+	HealthCheck HealthCheck
+	// Transport and TLS are the options of the Discover, the values
+	// defined in the plugin URI take precedence
+	Transport discoverlib.TransportOptions
+	TLS       discoverlib.TLSOptions
+	// Outliers enables the outlier detection, nil disables it
+	Outliers *OutlierDetector
+	// CircuitBreaker enables the circuit breaker, nil disables it
+	CircuitBreaker *CircuitBreaker
+	// SlowStart ramps up the weight of new and recovered resources, nil
+	// disables it
+	SlowStart *SlowStart
+	// Metrics of the resource, DefaultMetrics if nil
+	Metrics *Metrics
+	// Label of the Discover of the resource in the metrics
+	Label string
+	// Tracer of the health checks and the dials, no tracing if nil
+	Tracer trace.Tracer
+	// Logger of the resource, nothing is logged if nil
+	Logger discoverlib.Logger
+}
+
 type Resource struct {
-	Protocol     string
-	Host         string
-	useTLS       bool
+	Protocol string
+	Host     string
+	useTLS   bool
+	// Transport of the resource. Its responses and errors, other than
+	// the dial errors, are not seen by the outlier detection and the
+	// circuit breaker, use RoundTrip for them
 	Transport    *http.Transport
+	dialer       *CustomDialer
+	transport    discoverlib.TransportOptions
+	tls          *tlsLoader
+	servername   string
 	HealthCheck  HealthCheck
-	lastUpdate   time.Time
+	plugin       discoverlib.Plugin
+	lastUpdate   int64
+	lastCheck    atomic.Value
 	healthStatus int64
-	close        bool
+	probes       int
+	successes    int
+	failures     int
+	closed       int32
+	done         chan struct{}
+	// ctx of the probes, cancelled by Close
+	ctx          context.Context
+	cancel       context.CancelFunc
+	outliers     *OutlierDetector
+	outlierState outlierState
+	ejectedUntil int64
+	breaker      *breaker
+	inFlight     int64
+	weight       int64
+	priority     int
+	zone         string
+	slowStart    *slowStart
+	recoveredAt  int64
+	metrics      *Metrics
+	tracer       trace.Tracer
+	logger       discoverlib.Logger
 }
 
-func New(p discoverlib.Plugin, host string, useTLS bool, Healthcheck HealthCheck) *Resource {
-	customDialer := newCustomDialer(host)
+func New(c Config) *Resource {
+	opts := transportOptions(c.Transport.Merge(c.Plugin.Transport()))
+	logger := discoverlib.With(c.Logger)
+	tlsLoader := newTLSLoader(c.TLS.Merge(c.Plugin.TLS()))
+	tlsLoader.logger = logger
+	servername := c.Plugin.Hostname()
+	if net.ParseIP(servername) != nil {
+		servername = ""
+	}
+	metrics := c.Metrics
+	if metrics == nil {
+		metrics = DefaultMetrics()
+	}
+	metrics = metrics.with(c.Label)
+	metrics.acquire(c.Host)
+	tracer := discoverlib.Tracer(c.Tracer)
+	customDialer := newCustomDialer(c.Host, opts, tlsLoader, servername, metrics)
+	customDialer.tracer = tracer
+	// The Transport has its own dialer to observe the connection errors
+	transportDialer := &CustomDialer{}
+	*transportDialer = *customDialer
+	protocol := strings.ToLower(c.Plugin.Protocol())
+	if c.UseTLS {
+		protocol = "https"
+	} else if protocol == "" {
+		protocol = "http"
+	}
+
 	r := &Resource{
-		Host:        host,
-		Protocol:    p.Protocol(),
-		HealthCheck: Healthcheck,
+		Host:        c.Host,
+		Protocol:    protocol,
+		useTLS:      protocol == "https",
+		HealthCheck: c.HealthCheck,
 		Transport: &http.Transport{
-			MaxIdleConns:          100,
-			IdleConnTimeout:       defaultIdleConnTimeout,
-			TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
-			ExpectContinueTimeout: defaultExpectContinueTimeout,
-			ResponseHeaderTimeout: p.Timeout(),
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-			ForceAttemptHTTP2: false,
-			DialContext:       customDialer.DialContext,
-			DialTLSContext:    customDialer.DialTLSContext,
+			MaxIdleConns:          opts.MaxIdleConns,
+			MaxIdleConnsPerHost:   opts.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       opts.MaxConnsPerHost,
+			IdleConnTimeout:       opts.IdleConnTimeout,
+			TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
+			ExpectContinueTimeout: opts.ExpectContinueTimeout,
+			ResponseHeaderTimeout: opts.ResponseHeaderTimeout,
+			ForceAttemptHTTP2:     opts.HTTP2 != nil && *opts.HTTP2,
+			DialContext:           transportDialer.DialContext,
+			DialTLSContext:        transportDialer.DialTLSContext,
 		},
-		lastUpdate: time.Now(),
+		dialer:     customDialer,
+		transport:  opts,
+		tls:        tlsLoader,
+		servername: servername,
+		outliers:   c.Outliers,
+		weight:     c.Plugin.Weight(),
+		priority:   c.Plugin.Priority(),
+		zone:       c.Plugin.Zone(c.Host),
+		plugin:     c.Plugin,
+		done:       make(chan struct{}),
+		metrics:    metrics,
+		tracer:     tracer,
+		logger:     logger,
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.Update()
+	r.recovered(time.Now())
+	if c.SlowStart != nil {
+		r.slowStart = newSlowStart(*c.SlowStart)
 	}
-	if r.HealthCheck.URL != "" {
+	if r.outliers != nil {
+		transportDialer.observe = r.observe
+		r.outliers.add(r)
+	}
+	if c.CircuitBreaker != nil {
+		r.breaker = newBreaker(r.Host, *c.CircuitBreaker, metrics)
+		r.breaker.logger = logger
+	}
+	if r.HealthCheck.enabled() {
 		go r.runHealthCheck()
 	} else {
 		r.healthStatus = 1
@@ -64,88 +176,274 @@ func New(p discoverlib.Plugin, host string, useTLS bool, Healthcheck HealthCheck
 	return r
 }
 
+// transportOptions fills the zero values with the defaults
+func transportOptions(o discoverlib.TransportOptions) discoverlib.TransportOptions {
+	return discoverlib.TransportOptions{
+		DialTimeout:           defaultDialTimeout,
+		KeepAlive:             defaultKeepAlive,
+		IdleConnTimeout:       defaultIdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ExpectContinueTimeout: defaultExpectContinueTimeout,
+		ResponseHeaderTimeout: defaultResponseHeaderTimeout,
+		MaxIdleConns:          defaultMaxIdleConns,
+	}.Merge(o)
+}
+
+// UseTLS is true when the resource is reached with https
+func (r *Resource) UseTLS() bool {
+	return r.useTLS
+}
+
+// URL returns the address of the resource with its Protocol as scheme
+func (r *Resource) URL(path string) *url.URL {
+	u := &url.URL{
+		Scheme: r.Protocol,
+		Host:   r.Host,
+	}
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		u.Path, u.RawQuery = path[:i], path[i+1:]
+	} else {
+		u.Path = path
+	}
+	return u
+}
+
+// RoundTrip sends the request with the Transport of the resource. The
+// scheme of the URL is replaced by the Protocol of the resource, so TLS
+// is used when the plugin was declared like "dns+https://". The result
+// goes to the circuit breaker and the outlier detection, which only see
+// the dial errors of the requests sent directly with the Transport
+func (r *Resource) RoundTrip(req *http.Request) (*http.Response, error) {
+	var generation uint64
+	if r.breaker != nil {
+		var ok bool
+		if generation, ok = r.breaker.acquire(); !ok {
+			return nil, ErrCircuitOpen
+		}
+	}
+	atomic.AddInt64(&r.inFlight, 1)
+	defer atomic.AddInt64(&r.inFlight, -1)
+
+	if req.URL.Scheme != r.Protocol {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = r.Protocol
+	}
+	res, err := r.Transport.RoundTrip(req)
+
+	code := 0
+	if res != nil {
+		code = res.StatusCode
+	}
+	if r.breaker != nil {
+		r.breaker.done(generation, err != nil || code >= 500)
+	}
+	if r.outliers != nil {
+		r.observeRoundTrip(code, err)
+	}
+	return res, err
+}
+
+// InFlight are the requests sent with RoundTrip waiting for the response
+func (r *Resource) InFlight() int64 {
+	return atomic.LoadInt64(&r.inFlight)
+}
+
+// Priority of the plugin of the resource, 0 is the highest
+func (r *Resource) Priority() int {
+	return r.priority
+}
+
+// Zone of the resource given by its plugin, empty if it is unknown
+func (r *Resource) Zone() string {
+	return r.zone
+}
+
+// CircuitState returns CircuitClosed, CircuitOpen or CircuitHalfOpen
+func (r *Resource) CircuitState() int {
+	if r.breaker == nil {
+		return CircuitClosed
+	}
+	return r.breaker.State()
+}
+
 func (r *Resource) Before(t time.Time) bool {
-	return r.lastUpdate.Add(1 * time.Minute).Before(t)
+	return r.LastUpdate().Add(1 * time.Minute).Before(t)
 }
 
 func (r *Resource) Update() {
-	r.lastUpdate = time.Now()
+	atomic.StoreInt64(&r.lastUpdate, time.Now().UnixNano())
+}
+
+// LastUpdate is the last time the plugin sent the resource
+func (r *Resource) LastUpdate() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&r.lastUpdate))
+}
+
+// Plugin that discovered the resource
+func (r *Resource) Plugin() discoverlib.Plugin {
+	return r.plugin
 }
 
 func (r *Resource) IsHealthy() bool {
 	return atomic.LoadInt64(&r.healthStatus) == 1
 }
 
+// Available is true when the resource can receive requests, it is
+// healthy, not ejected by the outlier detection and the circuit breaker
+// lets requests through
+func (r *Resource) Available() bool {
+	return r.IsHealthy() && !r.IsEjected() && (r.breaker == nil || r.breaker.ready())
+}
+
+// Close stops the health checks, cancelling the probe in flight, and
+// removes the resource from the outlier detection and the metrics. The
+// dials and probes that end later don't write the metrics, the next
+// calls do nothing
 func (r *Resource) Close() {
-	r.close = true
+	if !atomic.CompareAndSwapInt32(&r.closed, 0, 1) {
+		return
+	}
+	close(r.done)
+	r.cancel()
+	if r.outliers != nil {
+		r.outliers.remove(r)
+	}
 	r.Transport.CloseIdleConnections()
+	r.metrics.release(r.Host)
 }
 
 func (r *Resource) IsClose() bool {
-	return r.close
+	return atomic.LoadInt32(&r.closed) == 1
 }
 
 func (r *Resource) runHealthCheck() {
-	interval, _ := time.ParseDuration(r.HealthCheck.Interval)
-	if interval.Nanoseconds() == 0 {
-		interval = defaultInterval
-	}
+	t := time.NewTimer(r.HealthCheck.firstWait())
+	defer t.Stop()
 	for {
-		if r.close {
+		select {
+		case <-t.C:
+		case <-r.done:
 			return
 		}
 		r.doHealthCheck()
-		time.Sleep(interval)
+		t.Reset(r.HealthCheck.wait())
 	}
 }
 
 func (r *Resource) doHealthCheck() bool {
-	if r.HealthCheck.URL != "" && !r.isNodeHealthy(r.HealthCheck.URL) {
-		atomic.CompareAndSwapInt64(&r.healthStatus, 1, 0)
-		statUnhealthyNodes.WithLabelValues(r.Host).Add(1)
-		return false
+	ctx, span := discoverlib.Tracer(r.tracer).Start(r.ctx, "discover.healthcheck",
+		trace.WithAttributes(r.Attributes()...),
+		trace.WithAttributes(attribute.String("discover.healthcheck.type", r.HealthCheck.kind())))
+	start := time.Now()
+	err := r.probe(ctx)
+	healthy := r.setHealth(err == nil)
+	span.SetAttributes(attribute.Bool("discover.resource.healthy", healthy))
+	discoverlib.EndSpan(span, err)
+	// Ignored if the resource was closed during the probe
+	r.metrics.check(r.Host, time.Since(start), err, healthy)
+	r.lastCheck.Store(CheckResult{Time: time.Now(), Err: err})
+	return healthy
+}
+
+// setHealth counts the consecutive results of the probes and changes
+// the status when they reach the threshold
+func (r *Resource) setHealth(ok bool) bool {
+	first := r.probes == 0
+	r.probes++
+	if ok {
+		r.successes++
+		r.failures = 0
+	} else {
+		r.failures++
+		r.successes = 0
+	}
+
+	switch {
+	case ok && (first || r.successes >= r.HealthCheck.healthyThreshold()):
+		if atomic.CompareAndSwapInt64(&r.healthStatus, 0, 1) && !first {
+			r.recovered(time.Now())
+			r.log().Info("resource healthy")
+		}
+	case !ok && (first || r.failures >= r.HealthCheck.unhealthyThreshold()):
+		if atomic.CompareAndSwapInt64(&r.healthStatus, 1, 0) {
+			r.log().Warn("resource unhealthy", "failures", r.failures)
+		}
 	}
-	atomic.CompareAndSwapInt64(&r.healthStatus, 0, 1)
-	return true
+	return r.IsHealthy()
 }
 
-func (r *Resource) isNodeHealthy(orgurl string) bool {
-	req, err := http.NewRequest(http.MethodGet, orgurl, nil)
+func (r *Resource) checkHTTP(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, r.HealthCheck.method(), r.HealthCheck.URL, nil)
 	if err != nil {
-		log.Printf("Discover: error health check url: %s - %s", orgurl, err.Error())
-		return false
+		r.log().Error("invalid health check URL", "url", r.HealthCheck.URL, "error", err)
+		return err
 	}
 
-	_, p, _ := net.SplitHostPort(req.Host)
-	if p == "" {
-		p = "80"
+	// The health check goes to the resource, the host of the URL is only
+	// used for the Host header. Without host the resource address is used
+	customDialer := r.dialer
+	if req.URL.Host != "" {
+		if r.useTLS {
+			req.URL.Scheme = "https"
+		}
+		p := req.URL.Port()
+		if p == "" {
+			p = "80"
+			if req.URL.Scheme == "https" {
+				p = "443"
+			}
+		}
+		h, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			h = r.Host
+		}
+		if addr := net.JoinHostPort(h, p); addr != r.Host {
+			customDialer = newCustomDialer(addr, r.transport, r.tls, r.servername, nil)
+			customDialer.tracer = r.tracer
+		}
+	} else {
+		req.URL.Scheme = r.Protocol
+		req.URL.Host = r.Host
+		req.Host = r.Host
+	}
+	for k, v := range r.HealthCheck.Headers {
+		req.Header.Set(k, v)
+	}
+	if r.HealthCheck.Host != "" {
+		req.Host = r.HealthCheck.Host
 	}
 
-	h, _, _ := net.SplitHostPort(r.Host)
-	customDialer := newCustomDialer(h + ":" + p)
 	client := &http.Client{
 		Transport: &http.Transport{
-			DialContext:    customDialer.DialContext,
-			DialTLSContext: customDialer.DialTLSContext,
+			DialContext:       customDialer.DialContext,
+			DialTLSContext:    customDialer.DialTLSContext,
+			DisableKeepAlives: true,
 		},
-		Timeout: 2 * time.Second,
+		Timeout: r.HealthCheck.timeout(),
 	}
 	res, err := client.Do(req)
 	if err != nil {
-		return false
+		return err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != r.HealthCheck.RespCode {
-		io.Copy(ioutil.Discard, res.Body)
-		return false
+	if !r.HealthCheck.statusCodes().contains(res.StatusCode) {
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, limitRange))
+		return fmt.Errorf("%w: %d", ErrUnexpectedStatus, res.StatusCode)
 	}
 
-	if r.HealthCheck.RespContent != "" {
-		b, _ := ioutil.ReadAll(res.Body)
-		return bytes.Contains(b, *(*[]byte)(unsafe.Pointer(&r.HealthCheck.RespContent)))
+	hc := r.HealthCheck
+	if hc.RespContent == "" && hc.RespRegexp == "" && len(hc.RespJSON) == 0 {
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, limitRange))
+		return nil
 	}
+	return hc.checkBody(io.LimitReader(res.Body, limitRange))
+}
 
-	io.Copy(ioutil.Discard, res.Body)
-	return true
+// log returns the Logger, NopLogger for the resources created without New
+func (r *Resource) log() discoverlib.Logger {
+	if r.logger == nil {
+		return discoverlib.NopLogger
+	}
+	return r.logger
 }
@@ -0,0 +1,62 @@
+package resource
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+type testPlugin struct {
+	protocol  string
+	priority  int
+	zone      string
+	hostname  string
+	transport discoverlib.TransportOptions
+	tls       discoverlib.TLSOptions
+}
+
+func (p *testPlugin) Get() chan []string                      { return nil }
+func (p *testPlugin) Protocol() string                        { return p.protocol }
+func (p *testPlugin) Weight() int64                           { return 0 }
+func (p *testPlugin) Priority() int                           { return p.priority }
+func (p *testPlugin) Zone(string) string                      { return p.zone }
+func (p *testPlugin) Transport() discoverlib.TransportOptions { return p.transport }
+func (p *testPlugin) TLS() discoverlib.TLSOptions             { return p.tls }
+func (p *testPlugin) Hostname() string                        { return p.hostname }
+func (p *testPlugin) Status() discoverlib.Status              { return discoverlib.Status{} }
+func (p *testPlugin) Exit()                                   {}
+
+func TestTransportOptions(t *testing.T) {
+	p := &testPlugin{
+		transport: discoverlib.TransportOptions{
+			DialTimeout:     time.Second,
+			MaxConnsPerHost: 10,
+		},
+	}
+	r := New(Config{
+		Plugin: p,
+		Host:   "127.0.0.1:80",
+		Transport: discoverlib.TransportOptions{
+			DialTimeout:           3 * time.Second,
+			ResponseHeaderTimeout: 20 * time.Second,
+		},
+	})
+	defer r.Close()
+
+	if r.transport.DialTimeout != time.Second {
+		t.Errorf("Plugin options don't override: %v", r.transport.DialTimeout)
+	}
+	if r.Transport.ResponseHeaderTimeout != 20*time.Second {
+		t.Errorf("Invalid response header timeout %v", r.Transport.ResponseHeaderTimeout)
+	}
+	if r.Transport.MaxConnsPerHost != 10 {
+		t.Errorf("Invalid max conns per host %v", r.Transport.MaxConnsPerHost)
+	}
+	if r.Transport.MaxIdleConns != defaultMaxIdleConns || r.Transport.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Errorf("Defaults not applied %+v", r.transport)
+	}
+	if r.Transport.ForceAttemptHTTP2 {
+		t.Error("HTTP2 enabled by default")
+	}
+}
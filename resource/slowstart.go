@@ -0,0 +1,102 @@
+package resource
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+var (
+	defaultSlowStartMinWeight  = 0.1
+	defaultSlowStartAggression = 1.0
+)
+
+// SlowStart ramps up the weight of the new resources, and the ones that
+// recover from a failed health check or an ejection, during Window. The
+// weight grows from MinWeight to 1 following (elapsed/Window)^(1/Aggression),
+// linear by default. Aggression greater than 1 ramps up faster at the start
+type SlowStart struct {
+	Window     string  `json:"window,omitempty"`
+	MinWeight  float64 `json:"min_weight,omitempty"`
+	Aggression float64 `json:"aggression,omitempty"`
+}
+
+// Validate returns FieldErrors with all the invalid fields
+func (s SlowStart) Validate() error {
+	var errs FieldErrors
+	if _, err := time.ParseDuration(s.Window); err != nil {
+		errs = append(errs, &FieldError{Field: "window", Err: err})
+	}
+	if s.MinWeight < 0 || s.MinWeight > 1 {
+		errs = append(errs, &FieldError{Field: "min_weight", Err: fmt.Errorf("%w: %v", discoverlib.ErrInvalidValue, s.MinWeight)})
+	}
+	if s.Aggression < 0 {
+		errs = append(errs, &FieldError{Field: "aggression", Err: fmt.Errorf("%w: %v", discoverlib.ErrInvalidValue, s.Aggression)})
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+type slowStart struct {
+	window     time.Duration
+	minWeight  float64
+	aggression float64
+}
+
+func newSlowStart(c SlowStart) *slowStart {
+	s := &slowStart{
+		window:     duration(c.Window, 0),
+		minWeight:  c.MinWeight,
+		aggression: c.Aggression,
+	}
+	if s.window == 0 {
+		return nil
+	}
+	if s.minWeight == 0 {
+		s.minWeight = defaultSlowStartMinWeight
+	}
+	if s.aggression == 0 {
+		s.aggression = defaultSlowStartAggression
+	}
+	return s
+}
+
+func (s *slowStart) factor(elapsed time.Duration) float64 {
+	if elapsed >= s.window {
+		return 1
+	}
+	if elapsed < 0 {
+		return s.minWeight
+	}
+	return math.Max(s.minWeight, math.Pow(float64(elapsed)/float64(s.window), 1/s.aggression))
+}
+
+// recovered starts the slow start window at t
+func (r *Resource) recovered(t time.Time) {
+	atomic.StoreInt64(&r.recoveredAt, t.UnixNano())
+}
+
+// SlowStartFactor is the fraction of the weight used by the resource,
+// between the MinWeight of SlowStart and 1
+func (r *Resource) SlowStartFactor() float64 {
+	if r.slowStart == nil {
+		return 1
+	}
+	elapsed := time.Duration(time.Now().UnixNano() - atomic.LoadInt64(&r.recoveredAt))
+	return r.slowStart.factor(elapsed)
+}
+
+// Weight is the weight of the plugin, 1 if it is not defined, multiplied
+// by the SlowStartFactor
+func (r *Resource) Weight() float64 {
+	w := float64(r.weight)
+	if w <= 0 {
+		w = 1
+	}
+	return w * r.SlowStartFactor()
+}
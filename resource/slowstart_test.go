@@ -0,0 +1,50 @@
+package resource
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	tests := []struct {
+		c       SlowStart
+		elapsed time.Duration
+		factor  float64
+	}{
+		{SlowStart{Window: "10s"}, 0, 0.1},
+		{SlowStart{Window: "10s"}, 5 * time.Second, 0.5},
+		{SlowStart{Window: "10s"}, 10 * time.Second, 1},
+		{SlowStart{Window: "10s", MinWeight: 0.3}, time.Second, 0.3},
+		{SlowStart{Window: "10s", Aggression: 2}, 2500 * time.Millisecond, 0.5},
+	}
+	for _, tt := range tests {
+		if f := newSlowStart(tt.c).factor(tt.elapsed); math.Abs(f-tt.factor) > 1e-9 {
+			t.Errorf("%+v %v: factor %v != %v", tt.c, tt.elapsed, f, tt.factor)
+		}
+	}
+}
+
+func TestSlowStartRecovered(t *testing.T) {
+	r := New(Config{
+		Plugin:    &testPlugin{},
+		Host:      "127.0.0.1:80",
+		SlowStart: &SlowStart{Window: "1h"},
+	})
+	defer r.Close()
+
+	if f := r.SlowStartFactor(); f >= 0.2 {
+		t.Errorf("New resource without slow start: %v", f)
+	}
+	r.recovered(time.Now().Add(-time.Hour))
+	if f := r.SlowStartFactor(); f != 1 || r.Weight() != 1 {
+		t.Errorf("Slow start not finished: %v", f)
+	}
+
+	// Recovered from a failed health check
+	r.setHealth(false)
+	r.setHealth(true)
+	if f := r.SlowStartFactor(); f >= 0.2 {
+		t.Errorf("Recovered resource without slow start: %v", f)
+	}
+}
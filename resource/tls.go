@@ -0,0 +1,131 @@
+package resource
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+var (
+	// ErrInvalidCA when the CA file doesn't contain any PEM certificate
+	ErrInvalidCA = errors.New("Invalid CA bundle")
+
+	tlsReloadInterval = 5 * time.Second
+)
+
+// tlsLoader builds the tls.Config from the TLSOptions and loads again
+// the files when their modification time changes
+type tlsLoader struct {
+	sync.Mutex
+	opts    discoverlib.TLSOptions
+	cfg     *tls.Config
+	err     error
+	mtimes  []time.Time
+	checked time.Time
+	logger  discoverlib.Logger
+}
+
+func newTLSLoader(opts discoverlib.TLSOptions) *tlsLoader {
+	return &tlsLoader{opts: opts}
+}
+
+func (l *tlsLoader) log() discoverlib.Logger {
+	if l.logger == nil {
+		return discoverlib.NopLogger
+	}
+	return l.logger
+}
+
+// Config returns the current configuration, the files are checked at
+// most every tlsReloadInterval. If a reload fails the previous
+// configuration is kept
+func (l *tlsLoader) Config() (*tls.Config, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.cfg != nil && time.Since(l.checked) < tlsReloadInterval {
+		return l.cfg, nil
+	}
+	l.checked = time.Now()
+
+	mtimes := l.modTimes()
+	if l.cfg != nil && equalTimes(mtimes, l.mtimes) {
+		return l.cfg, nil
+	}
+
+	cfg, err := l.load()
+	if err != nil {
+		if l.cfg != nil {
+			l.log().Warn("keeping previous TLS config", "error", err)
+			return l.cfg, nil
+		}
+		return nil, err
+	}
+	l.cfg, l.mtimes = cfg, mtimes
+	return cfg, nil
+}
+
+func (l *tlsLoader) load() (*tls.Config, error) {
+	version, err := discoverlib.ParseTLSVersion(l.opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		ServerName:         l.opts.ServerName,
+		MinVersion:         version,
+		InsecureSkipVerify: l.opts.InsecureSkipVerify,
+	}
+
+	if l.opts.CAFile != "" {
+		b, err := ioutil.ReadFile(l.opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(b) {
+			return nil, ErrInvalidCA
+		}
+	}
+
+	if l.opts.CertFile != "" || l.opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(l.opts.CertFile, l.opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (l *tlsLoader) modTimes() []time.Time {
+	files := []string{l.opts.CAFile, l.opts.CertFile, l.opts.KeyFile}
+	mtimes := make([]time.Time, len(files))
+	for i, f := range files {
+		if f == "" {
+			continue
+		}
+		if st, err := os.Stat(f); err == nil {
+			mtimes[i] = st.ModTime()
+		}
+	}
+	return mtimes
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
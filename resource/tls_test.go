@@ -0,0 +1,169 @@
+package resource
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+func writeCA(t *testing.T, dir string, srv *httptest.Server) string {
+	path := filepath.Join(dir, "ca.pem")
+	b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTLSVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := writeCA(t, dir, srv)
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	tests := []struct {
+		name     string
+		hostname string
+		tls      discoverlib.TLSOptions
+		valid    bool
+	}{
+		{"system roots", "example.com", discoverlib.TLSOptions{}, false},
+		{"plugin hostname", "example.com", discoverlib.TLSOptions{CAFile: ca}, true},
+		{"invalid server name", "example.com", discoverlib.TLSOptions{CAFile: ca, ServerName: "other.com"}, false},
+		{"IP hostname", "127.0.0.1", discoverlib.TLSOptions{CAFile: ca}, true},
+		{"insecure", "other.com", discoverlib.TLSOptions{InsecureSkipVerify: true}, true},
+	}
+	for _, tt := range tests {
+		r := New(Config{
+			Plugin: &testPlugin{hostname: tt.hostname},
+			Host:   host,
+			TLS:    tt.tls,
+		})
+		res, err := (&http.Client{Transport: r.Transport}).Get("https://" + host + "/")
+		if err == nil {
+			res.Body.Close()
+		}
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got %v", tt.name, tt.valid, err)
+		}
+		r.Close()
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "discover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	l := newTLSLoader(discoverlib.TLSOptions{CAFile: ca})
+	if _, err := l.Config(); err != ErrInvalidCA {
+		t.Fatalf("Expected ErrInvalidCA, got %v", err)
+	}
+
+	writeCA(t, dir, srv)
+	if _, err := l.Config(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(d time.Duration) { tlsReloadInterval = d }(tlsReloadInterval)
+	tlsReloadInterval = 0
+	cfg, _ := l.Config()
+	if err := ioutil.WriteFile(ca, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(ca, time.Now(), time.Now().Add(time.Minute))
+	if c, err := l.Config(); err != nil || c != cfg {
+		t.Errorf("Previous config not kept after an invalid reload: %v", err)
+	}
+}
+
+func TestTLSDialContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		// Accept without handshake
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	cd := newCustomDialer(ln.Addr().String(), transportOptions(discoverlib.TransportOptions{}), newTLSLoader(discoverlib.TLSOptions{}), "", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := cd.DialTLSContext(ctx, "tcp", ln.Addr().String()); err == nil {
+		t.Fatal("Expected handshake error")
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Handshake not cancelled by the context: %v", time.Since(start))
+	}
+}
+
+func TestHTTPSProtocol(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			w.Write([]byte("OK"))
+		}
+	}))
+	defer srv.Close()
+
+	host := strings.TrimPrefix(srv.URL, "https://")
+	r := New(Config{
+		Plugin: &testPlugin{protocol: "https"},
+		Host:   host,
+		TLS:    discoverlib.TLSOptions{InsecureSkipVerify: true},
+	})
+	defer r.Close()
+
+	if !r.UseTLS() {
+		t.Fatal("https protocol without TLS")
+	}
+	if u := r.URL("/path?q=1"); u.String() != "https://"+host+"/path?q=1" {
+		t.Errorf("Invalid URL %s", u)
+	}
+
+	res, err := (&http.Client{Transport: r}).Get("http://" + host + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	r.HealthCheck = HealthCheck{URL: "/health", RespCode: http.StatusOK, RespContent: "OK"}
+	if !r.doHealthCheck() {
+		t.Error("Health check without TLS")
+	}
+}
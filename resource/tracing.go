@@ -0,0 +1,39 @@
+package resource
+
+import (
+	"net"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+// Attributes of the resource added to the spans
+func (r *Resource) Attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("discover.resource.host", r.Host),
+		attribute.String("discover.resource.protocol", r.Protocol),
+		attribute.Int("discover.resource.priority", r.priority),
+	}
+	if r.zone != "" {
+		attrs = append(attrs, attribute.String("discover.resource.zone", r.zone))
+	}
+	return append(attrs, peerAttributes(r.Host)...)
+}
+
+func peerAttributes(addr string) []attribute.KeyValue {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []attribute.KeyValue{semconv.NetPeerNameKey.String(addr)}
+	}
+	attrs := make([]attribute.KeyValue, 0, 2)
+	if net.ParseIP(host) != nil {
+		attrs = append(attrs, semconv.NetPeerIPKey.String(host))
+	} else {
+		attrs = append(attrs, semconv.NetPeerNameKey.String(host))
+	}
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, semconv.NetPeerPortKey.Int(p))
+	}
+	return attrs
+}
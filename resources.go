@@ -1,7 +1,9 @@
 package discover
 
 import (
-	"log"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/gabrielperezs/discover/discoverlib"
@@ -10,25 +12,57 @@ import (
 
 type Resources []*resource.Resource
 
-func (d *Resources) update(p discoverlib.Plugin, addrs []string, hc resource.HealthCheck) (updates bool) {
+// update creates the resources of the plugin with c as template and
+// closes its expired ones. The addresses that can't be used are skipped
+// and returned in the error
+func (d *Resources) update(p discoverlib.Plugin, addrs []string, c resource.Config) (added, removed int, err error) {
+	logger := c.Logger
 	t := time.Now()
 	for _, addr := range addrs {
 		if r := d.exists(addr); r != nil {
 			r.Update()
 			continue
 		}
-		r := resource.New(p, addr, false, hc)
+		c.Plugin = p
+		c.Host = addr
+		c.UseTLS = strings.EqualFold(p.Protocol(), "https")
+		c.Logger = discoverlib.With(logger, "host", addr)
+		r := resource.New(c)
 		if r == nil {
-			log.Panicf("What?")
+			err = fmt.Errorf("%w: %s", ErrInvalidResource, addr)
+			c.Logger.Error("resource not created")
+			continue
 		}
+		c.Logger.Info("resource added")
 		*d = append(*d, r)
-		updates = true
+		added++
+	}
+
+	removed = d.expire(p, t, logger)
+	return
+}
+
+// expire closes the resources of the plugin not updated before t. Only
+// the plugin expires its resources, other sources may send updates less
+// often, like k8s that only sends them with the events
+func (d *Resources) expire(p discoverlib.Plugin, t time.Time, logger discoverlib.Logger) (removed int) {
+	for _, r := range *d {
+		if r.Plugin() == p && !r.IsClose() && r.Before(t) {
+			r.Close()
+			discoverlib.With(logger, "host", r.Host).Info("resource removed")
+			removed++
+		}
 	}
+	return
+}
 
+// remove closes the resources created by the plugin
+func (d *Resources) remove(p discoverlib.Plugin, logger discoverlib.Logger) (removed int) {
 	for _, r := range *d {
-		if r.Before(t) {
+		if r.Plugin() == p && !r.IsClose() {
 			r.Close()
-			updates = true
+			discoverlib.With(logger, "host", r.Host).Info("resource removed")
+			removed++
 		}
 	}
 	return
@@ -54,6 +88,25 @@ func (d *Resources) clean() {
 	*d = n
 }
 
+// tiers groups the resources by priority, the highest first
+func (d Resources) tiers() []Resources {
+	byPriority := make(map[int]Resources)
+	priorities := make([]int, 0)
+	for _, r := range d {
+		p := r.Priority()
+		if _, ok := byPriority[p]; !ok {
+			priorities = append(priorities, p)
+		}
+		byPriority[p] = append(byPriority[p], r)
+	}
+	sort.Ints(priorities)
+	tiers := make([]Resources, 0, len(priorities))
+	for _, p := range priorities {
+		tiers = append(tiers, byPriority[p])
+	}
+	return tiers
+}
+
 func (d *Resources) clone() Resources {
 	n := make(Resources, 0)
 	for _, v := range *d {
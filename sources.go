@@ -0,0 +1,192 @@
+package discover
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gabrielperezs/discover/discoverlib"
+)
+
+var (
+	ErrSourceExists  = errors.New("Source already exists")
+	ErrUnknownSource = errors.New("Unknown source")
+	ErrExited        = errors.New("Discover exited")
+)
+
+// source is a running plugin and the URI that created it
+type source struct {
+	uri    string
+	plugin discoverlib.Plugin
+	// updated is the UnixNano of the last update, 0 before the first one
+	updated int64
+	// done is closed when the source is removed
+	done chan struct{}
+	// removed is set by RemoveSource, its updates are ignored after it.
+	// Protected by resourcesMu
+	removed bool
+}
+
+// sourceUpdate are the hosts sent by the plugin of a source
+type sourceUpdate struct {
+	source *source
+	hosts  []string
+}
+
+// Plugins returns the running plugins
+func (d *Discover) Plugins() []discoverlib.Plugin {
+	sources := d.sources()
+	plugins := make([]discoverlib.Plugin, 0, len(sources))
+	for _, s := range sources {
+		plugins = append(plugins, s.plugin)
+	}
+	return plugins
+}
+
+// Sources returns the URIs of the running plugins
+func (d *Discover) Sources() []string {
+	sources := d.sources()
+	uris := make([]string, 0, len(sources))
+	for _, s := range sources {
+		uris = append(uris, s.uri)
+	}
+	return uris
+}
+
+func (d *Discover) sources() []*source {
+	if s, ok := d.atomicSources.Load().([]*source); ok {
+		return s
+	}
+	return nil
+}
+
+// AddSource starts a plugin for the URI at runtime, with the same options
+// as the DiscoverURI of the Config
+func (d *Discover) AddSource(uri string) error {
+	start, err := parsePlugin(uri, d.pluginBase(uri, d.lenient))
+	if err != nil {
+		return err
+	}
+
+	d.sourcesMu.Lock()
+	defer d.sourcesMu.Unlock()
+	// Checked with the lock, so Exit stops it or it is not added
+	if atomic.LoadInt32(&d.exit) == 1 {
+		return ErrExited
+	}
+	for _, s := range d.sources() {
+		if s.uri == uri {
+			return ErrSourceExists
+		}
+	}
+	d.add(uri, start())
+	return nil
+}
+
+// RemoveSource stops the plugin of the URI and closes the resources it
+// created. A host also sent by other source is created again with its
+// next update
+func (d *Discover) RemoveSource(uri string) error {
+	d.sourcesMu.Lock()
+	sources := d.sources()
+	var s *source
+	n := make([]*source, 0, len(sources))
+	for _, v := range sources {
+		if v.uri == uri {
+			s = v
+			continue
+		}
+		n = append(n, v)
+	}
+	if s == nil {
+		d.sourcesMu.Unlock()
+		return ErrUnknownSource
+	}
+	d.atomicSources.Store(n)
+	d.sourcesMu.Unlock()
+
+	close(s.done)
+	d.remove(s)
+	go func() {
+		s.plugin.Exit()
+		d.metrics.get().releasePlugin(d.Label, uri)
+	}()
+	return nil
+}
+
+// add registers the plugin and forwards its updates to the listener. It
+// must be called with sourcesMu locked
+func (d *Discover) add(uri string, p discoverlib.Plugin) *source {
+	s := &source{
+		uri:    uri,
+		plugin: p,
+		done:   make(chan struct{}),
+	}
+	d.metrics.get().acquirePlugin(d.Label, uri)
+	sources := d.sources()
+	n := make([]*source, 0, len(sources)+1)
+	n = append(n, sources...)
+	d.atomicSources.Store(append(n, s))
+	go d.forward(s)
+	return s
+}
+
+// forward sends the updates of the plugin to the listener. After the
+// removal of the source the channel is drained until the plugin closes
+// it, so the plugin never blocks in the Exit
+func (d *Discover) forward(s *source) {
+	for hosts := range s.plugin.Get() {
+		select {
+		case <-s.done:
+			continue
+		default:
+		}
+		select {
+		case d.updates <- sourceUpdate{source: s, hosts: hosts}:
+		case <-s.done:
+		}
+	}
+}
+
+// listener is the reconcile loop, it applies the updates of all the
+// sources one by one until Exit
+func (d *Discover) listener() {
+	for {
+		select {
+		case u := <-d.updates:
+			d.stats()
+			d.update(u.hosts, u.source)
+			// After the update, WaitReady sees the resources of the plugin
+			atomic.StoreInt64(&u.source.updated, time.Now().UnixNano())
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// stopSources removes all the sources and stops forwarding their updates
+func (d *Discover) stopSources() []*source {
+	d.sourcesMu.Lock()
+	sources := d.sources()
+	d.atomicSources.Store([]*source{})
+	d.sourcesMu.Unlock()
+
+	for _, s := range sources {
+		close(s.done)
+	}
+	return sources
+}
+
+// exitPlugins waits for the Exit of the plugins of the sources
+func (d *Discover) exitPlugins(sources []*source) {
+	wg := &sync.WaitGroup{}
+	for _, s := range sources {
+		wg.Add(1)
+		go func(p discoverlib.Plugin) {
+			p.Exit()
+			wg.Done()
+		}(s.plugin)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,105 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSources(t *testing.T) {
+	first, second := "dns://127.0.0.1:80?refresh=50ms", "dns://127.0.0.2:81?refresh=50ms"
+	d, err := New(Config{
+		Label:       "sources",
+		DiscoverURI: []string{first},
+		Registerer:  prometheus.NewRegistry(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Exit()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := d.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.AddSource(second); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddSource(second); !errors.Is(err, ErrSourceExists) {
+		t.Errorf("Expected ErrSourceExists, got %v", err)
+	}
+	if err := d.AddSource("ftp://127.0.0.3:80"); !errors.Is(err, ErrErrorPlugin) {
+		t.Errorf("Expected ErrErrorPlugin, got %v", err)
+	}
+	if err := d.WaitReady(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for d.Len() != 2 && ctx.Err() == nil {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d.Len() != 2 || len(d.Plugins()) != 2 {
+		t.Fatalf("Expected 2 resources and plugins, got %d and %d", d.Len(), len(d.Plugins()))
+	}
+
+	// The resources of the source are closed before RemoveSource returns
+	r := d.Resources()
+	if err := d.RemoveSource(first); err != nil {
+		t.Fatal(err)
+	}
+	if s := d.Sources(); len(s) != 1 || s[0] != second {
+		t.Errorf("Invalid sources %v", s)
+	}
+	if l := d.Resources(); len(l) != 1 || l[0].Host != "127.0.0.2:81" {
+		t.Errorf("Invalid resources after RemoveSource %v", l)
+	}
+	for _, l := range r {
+		if l.Host == "127.0.0.1:80" && !l.IsClose() {
+			t.Errorf("Resource of the removed source not closed")
+		}
+	}
+
+	// The updates sent by the plugin while it exits are ignored
+	time.Sleep(200 * time.Millisecond)
+	if d.Len() != 1 {
+		t.Errorf("Expected 1 resource, got %d", d.Len())
+	}
+
+	if err := d.RemoveSource(first); !errors.Is(err, ErrUnknownSource) {
+		t.Errorf("Expected ErrUnknownSource, got %v", err)
+	}
+}
+
+func TestExpireOwnResources(t *testing.T) {
+	a, b := &testPlugin{}, &testPlugin{}
+	d := &Discover{Label: "test"}
+	d.store(make(Resources, 0))
+	d.add("test://a", a)
+	d.add("test://b", b)
+	d.update([]string{"10.0.0.1:80"}, d.sources()[0])
+	d.update([]string{"10.0.0.2:80"}, d.sources()[1])
+	defer func() {
+		for _, r := range d.Resources() {
+			r.Close()
+		}
+	}()
+
+	// An update of b after the expiration time only closes its resource,
+	// like the refresh of DNS with a quiet k8s
+	if n := d.resources.expire(b, time.Now().Add(2*time.Minute), nil); n != 1 {
+		t.Errorf("Expected 1 expired resource, got %d", n)
+	}
+	for _, r := range d.Resources() {
+		if r.Plugin() == a && r.IsClose() {
+			t.Errorf("Resource of other source closed %s", r.Host)
+		}
+	}
+
+	if n := d.resources.expire(a, time.Now().Add(2*time.Minute), nil); n != 1 {
+		t.Errorf("Expected 1 expired resource, got %d", n)
+	}
+}
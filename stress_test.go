@@ -0,0 +1,156 @@
+package discover
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gabrielperezs/discover/resource"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestStress churns the sources, the health checks, the overrides and the
+// NextHealthy callers at the same time, run it with -race
+func TestStress(t *testing.T) {
+	duration := time.Second
+	if testing.Short() {
+		duration = 200 * time.Millisecond
+	}
+
+	uris := make([]string, 0)
+	for i := 0; i < 6; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Half of the resources fail the health checks
+		if i%2 == 0 {
+			defer ln.Close()
+		} else {
+			ln.Close()
+		}
+		_, port, _ := net.SplitHostPort(ln.Addr().String())
+		uris = append(uris, fmt.Sprintf("dns://127.0.0.1:%s?refresh=10ms&priority=%d", port, i%2))
+	}
+
+	d, err := New(Config{
+		Label:          "stress",
+		DiscoverURI:    uris[:2],
+		HealtCheck:     resource.HealthCheck{Type: resource.HealthCheckTCP, Interval: "5ms", Timeout: "50ms"},
+		CircuitBreaker: &resource.CircuitBreaker{},
+		SlowStart:      &resource.SlowStart{Window: "50ms"},
+		PanicThreshold: 0.5,
+		LocalZone:      "local",
+		Registerer:     prometheus.NewRegistry(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Exit()
+
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					f()
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 8; i++ {
+		run(func() {
+			if r := d.NextHealthy(); r != nil {
+				r.Available()
+			}
+		})
+	}
+	run(func() {
+		uri := uris[rand.Intn(len(uris))]
+		if err := d.AddSource(uri); err != nil && !errors.Is(err, ErrSourceExists) {
+			t.Errorf("AddSource: %v", err)
+		}
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+	})
+	run(func() {
+		uri := uris[rand.Intn(len(uris))]
+		if err := d.RemoveSource(uri); err != nil && !errors.Is(err, ErrUnknownSource) {
+			t.Errorf("RemoveSource: %v", err)
+		}
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+	})
+	run(func() {
+		for _, r := range d.Resources() {
+			d.Drain(r.Host, 10*time.Millisecond)
+		}
+		d.State()
+		d.Health()
+		time.Sleep(time.Millisecond)
+	})
+
+	time.Sleep(duration)
+	close(stop)
+	wg.Wait()
+
+	// Without churn only the resources of the running sources are left
+	plugins := d.Plugins()
+	hosts := make(map[string]bool)
+	for _, r := range d.Resources() {
+		if r.IsClose() {
+			t.Errorf("Closed resource %s", r.Host)
+		}
+		if hosts[r.Host] {
+			t.Errorf("Duplicated resource %s", r.Host)
+		}
+		hosts[r.Host] = true
+		found := false
+		for _, p := range plugins {
+			found = found || p == r.Plugin()
+		}
+		if !found {
+			t.Errorf("Resource %s of a removed source", r.Host)
+		}
+	}
+}
+
+// TestStressExit calls Exit while the sources are updated and churned
+func TestStressExit(t *testing.T) {
+	for i := 0; i < 5; i++ {
+		d, err := New(Config{
+			Label:       "stress-exit",
+			DiscoverURI: []string{"dns://127.0.0.1:80?refresh=1ms", "dns://127.0.0.2:80?refresh=1ms"},
+			Registerer:  prometheus.NewRegistry(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				if err := d.AddSource("dns://127.0.0.3:80?refresh=1ms"); errors.Is(err, ErrExited) {
+					return
+				}
+				d.RemoveSource("dns://127.0.0.3:80?refresh=1ms")
+				d.NextHealthy()
+			}
+		}()
+
+		time.Sleep(time.Duration(rand.Intn(20)) * time.Millisecond)
+		d.Exit()
+		d.Exit()
+		<-done
+	}
+}
@@ -0,0 +1,88 @@
+package discover
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gabrielperezs/discover/resource"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	d := newTestDiscover(t, &Discover{
+		Label:       "test",
+		healthCheck: resource.HealthCheck{URL: "/health", Interval: "20ms"},
+		tracer:      provider.Tracer("test"),
+	})
+	d.update([]string{host}, d.sources()[0])
+	eventually(t, func() bool { return !d.Resources()[0].LastCheck().Time.IsZero() })
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://backend/", nil)
+	res, err := d.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	span.End()
+
+	spans := make(map[string]tracetest.SpanStub)
+	dialed := false
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+		if s.Name == "discover.dial" && s.Parent.SpanID() == span.SpanContext().SpanID() {
+			dialed = true
+		}
+	}
+	for _, name := range []string{"dns.lookup", "discover.plugin.update", "discover.healthcheck", "discover.dial", "request"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("Span %s not found", name)
+		}
+	}
+	if !dialed {
+		t.Error("Dial span of the request not found")
+	}
+
+	found := false
+	for _, a := range spans["request"].Attributes {
+		if a == attribute.String("discover.resource.host", host) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Resource attributes not in the request span %v", spans["request"].Attributes)
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// A global provider is ignored without an explicit TracerProvider
+	exporter := tracetest.NewInMemoryExporter()
+	global := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(global)
+
+	d := newTestDiscover(t, &Discover{
+		Label:       "test",
+		healthCheck: resource.HealthCheck{URL: "/health", Interval: "20ms"},
+	})
+	d.update([]string{strings.TrimPrefix(srv.URL, "http://")}, d.sources()[0])
+	eventually(t, func() bool { return !d.Resources()[0].LastCheck().Time.IsZero() })
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("Expected no spans, got %d", len(spans))
+	}
+}